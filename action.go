@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -13,7 +16,10 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -27,127 +33,1953 @@ const (
 	EnvAWSRegion          = "AWS_REGION"
 )
 
-const awsRegionRegExp = `(us(-gov)?|ap|ca|cn|eu|sa)-(central|(north|south)?(east|west)?)-\d`
+// amzDateFormat is the layout of the X-Amz-Date header.
+const amzDateFormat = "20060102T150405Z"
 
-var (
-	lambdaURL     = flag.String("lambda-url", "", "The lambda function URL, should be https://<id>.lambda-url.<region>.on.aws/something.")
-	requestBody   = flag.String("body", "", "The body associated with the request (POST request).")
-	requestMethod = flag.String("method", "GET", "HTTP Method used to call the Lambda function.")
-	headerList    = flag.String("headers", "", "List of Headers")
-)
+// emptyPayloadHash is the hex encoded SHA-256 of an empty payload.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// defaultHashBodyMethods are the methods whose body is covered by the payload
+// hash unless -hash-body-methods says otherwise.
+const defaultHashBodyMethods = "POST,PUT,PATCH"
+
+// awsRegionRegExp matches a region as a whole host label, covering the
+// commercial, GovCloud (us-gov-*) and China (cn-*) partitions.
+const awsRegionRegExp = `(?:^|\.)((us(-gov)?|ap|ca|cn|eu|sa|me|af|il|mx)-(central|(north|south)?(east|west)?)-\d+)(?:\.|$)`
+
+// globalServiceRegions are the signing regions of global services, used
+// unless -region is given: CloudFront, its API as well as the origins signed
+// for Lambda@Edge, is signed in us-east-1 whatever AWS_REGION or the URL say.
+var globalServiceRegions = map[string]string{
+	"cloudfront": "us-east-1",
+}
+
+// reservedHeaders are set by the signature, a value given for them is lost.
+var reservedHeaders = []string{"Authorization", "X-Amz-Date", "X-Amz-Security-Token"}
+
+// options are the settings of a run, as given on the command line.
+type options struct {
+	lambdaURL          string
+	body               string
+	method             string
+	headers            string
+	outputFD           string
+	repeat             int
+	concurrency        int
+	timeout            string
+	verbose            bool
+	logFormat          string
+	ipVersion          string
+	region             string
+	noGuessRegion      bool
+	interpolateHeaders bool
+	service            string
+	contentMD5         bool
+	trace              bool
+	tlsInfo            bool
+	disableCompression bool
+	verifyCredentials  bool
+	validateBody       bool
+	summary            bool
+	clockSkew          string
+	failOnError        bool
+	accept             string
+	cache              bool
+	allowGetBody       bool
+	maxRedirects       int
+	signingTime        string
+	expectedSignature  string
+	detectContentType  bool
+	dateHeader         string
+	bodyFiles          []string
+	hashBufferSize     int
+	ifMatch            string
+	ifUnmodifiedSince  string
+	imdsRegion         bool
+	duplicateHeaders   string
+	notifyURL          string
+	notifyFormat       string
+	requestFile        string
+	maxHeaderBytes     int
+	responseBase64     bool
+	retries            int
+	retryBackoff       string
+	retryOnStatus      string
+	unixSocket         string
+	totalTimeout       string
+	expectHeaders      []string
+	anonymous          bool
+	pretty             bool
+	rawBodyFile        string
+	harFile            string
+	emitSignedHeaders  string
+	propagateTrace     bool
+	maxLatency         string
+	sessionTokenFile   string
+	preRequestHook     string
+	maxOutputBytes     int
+	pathStyle          bool
+	metricsFile        string
+	noCredentialCheck  bool
+	printConfig        bool
+	expectContentType  string
+	certRegion         bool
+	trimBody           bool
+	attemptLog         string
+	connectTimeout     string
+	disableKeepAlive   bool
+	dnsServer          string
+	responseSchema     string
+	invocationType     string
+	maxBodyBytes       int64
+	refreshCredentials bool
+	goldenFile         string
+	goldenJSON         bool
+	query              []string
+	hashBodyMethods    string
+	profiles           string
+	successOnBodyRegex string
+	openAPIExampleFile string
+	defaultHeadersFile string
+	credentialsProcess string
+	strict             bool
+	jq                 string
+	assumeRoleARN      string
+	roleSessionName    string
+	externalID         string
+	roleTags           []string
+	debugBundle        string
+	outputFile         string
+	dataURLEncode      []string
+	formParts          []formPart
+}
 
 func main() {
-	flag.Parse()
+	os.Exit(run(os.Args[1:], environ(os.Environ()), os.Stdout, os.Stderr))
+}
+
+// environ turns KEY=value pairs into a map.
+func environ(pairs []string) map[string]string {
+	env := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		if i := strings.Index(pair, "="); i > 0 {
+			env[pair[:i]] = pair[i+1:]
+		}
+	}
+	return env
+}
+
+// parseFlags parses the command line of the action, usage and errors are
+// written to output.
+func parseFlags(args []string, output io.Writer) (options, error) {
+	var opts options
+	fs := flag.NewFlagSet("aws-sigv4-action", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.lambdaURL, "lambda-url", "", "The lambda function URL, should be https://<id>.lambda-url.<region>.on.aws/something.")
+	fs.StringVar(&opts.body, "body", "", "The body associated with the request (POST request), sent and signed verbatim, trailing newline included unless -trim-body is set.")
+	fs.StringVar(&opts.method, "method", "", "HTTP Method used to call the Lambda function, defaults to GET or to POST when sending -data-urlencode form data. The method of -request-file takes precedence.")
+	fs.StringVar(&opts.headers, "headers", "", "List of Headers")
+	fs.StringVar(&opts.outputFD, "output-fd", "", "File descriptor number or named pipe path to stream the response body to, instead of the message output.")
+	fs.IntVar(&opts.repeat, "repeat", 1, "Number of times to send the signed request, reporting aggregate stats instead of the response.")
+	fs.IntVar(&opts.concurrency, "concurrency", 1, "Number of workers sending requests when -repeat is greater than 1.")
+	fs.StringVar(&opts.timeout, "timeout", "5s", "Timeout of each HTTP request as a duration (e.g. 500ms, 30s), 0 disables it.")
+	fs.BoolVar(&opts.verbose, "verbose", false, "Log details about the signed request and the response.")
+	fs.StringVar(&opts.logFormat, "log-format", logFormatText, "Format of the log lines, text or json (one JSON object per line on stderr).")
+	fs.StringVar(&opts.ipVersion, "ip-version", "auto", "IP version used to connect: 4, 6 or auto.")
+	fs.StringVar(&opts.region, "region", "", "AWS region used to sign the request, takes precedence over the AWS_REGION env variable. Defaults to us-east-1 for the cloudfront service, a global one.")
+	fs.BoolVar(&opts.noGuessRegion, "no-guess-region", false, "Never guess the region from the URL (e.g. when calling through a proxy), -region or AWS_REGION is then required.")
+	fs.BoolVar(&opts.interpolateHeaders, "interpolate-headers", false, "Replace ${VAR} references in header values with env variables before signing.")
+	fs.StringVar(&opts.service, "service", "lambda", "Signing name of the AWS service called, e.g. lambda, execute-api, s3 or cloudfront.")
+	fs.BoolVar(&opts.contentMD5, "content-md5", false, "Send the base64 MD5 digest of the body as a signed Content-MD5 header (validated by S3).")
+	fs.BoolVar(&opts.trace, "trace", false, "Report DNS, connect, TLS handshake and time to first byte timings of the request.")
+	fs.BoolVar(&opts.tlsInfo, "tls-info", false, "Report the subject, issuer and expiry of the certificate served by the endpoint.")
+	fs.BoolVar(&opts.disableCompression, "disable-compression", false, "Do not request gzip transparently, only responses encoded on explicit request (Accept-Encoding header) are decoded.")
+	fs.BoolVar(&opts.verifyCredentials, "verify-credentials", false, "Check the credentials with sts:GetCallerIdentity before sending the request.")
+	fs.BoolVar(&opts.validateBody, "validate-body", false, "Check the body is well-formed for the declared Content-Type (JSON, form data) before sending it.")
+	fs.BoolVar(&opts.summary, "summary", false, "Append the status, code, duration and request ID to the GitHub job summary, or why the request failed, or the stats of a -repeat run.")
+	fs.StringVar(&opts.clockSkew, "clock-skew", "", "Offset added to the signing time (e.g. -90s, 2m) for runners with a skewed clock.")
+	fs.BoolVar(&opts.failOnError, "fail-on-error", false, "Exit non-zero when the response status is 4xx or 5xx, after the outputs are written, unless the body matches -success-on-body-regex.")
+	fs.StringVar(&opts.accept, "accept", "", "Accept header of the request (e.g. application/json), signed like the other headers.")
+	fs.BoolVar(&opts.cache, "cache", false, "Reuse fresh GET responses and revalidate stale ones (Cache-Control, ETag) across the requests of a -repeat run.")
+	fs.BoolVar(&opts.allowGetBody, "allow-get-body", false, "Send and sign the body of a GET request, which is non-standard but expected by some services (e.g. search APIs). Not signed when -hash-body-methods is given without GET.")
+	fs.IntVar(&opts.maxRedirects, "max-redirects", 0, "Maximum number of redirects followed, 0 returns the redirect response itself. Hops to the same host over HTTPS are signed again, those to another host are sent without credentials, a downgrade from HTTPS to HTTP is refused.")
+	fs.StringVar(&opts.signingTime, "signing-time", "", "Fixed signing time (e.g. 2006-01-02T15:04:05Z) instead of the current time, to reproduce a signature. X-Amz-Date and the date of the credential scope are both derived from it, in UTC.")
+	fs.StringVar(&opts.expectedSignature, "expected-signature", "", "Fail before sending when the computed signature differs from this one, to check signing fixtures (combine with -signing-time).")
+	fs.BoolVar(&opts.detectContentType, "detect-content-type", false, "Detect the Content-Type of a body (and of -form-file parts with an unknown extension) from its content when none is given.")
+	fs.StringVar(&opts.dateHeader, "date-header", "", "Extra header mirroring the signed X-Amz-Date, for proxies expecting the date under another name. The copy is signed too.")
+	fs.IntVar(&opts.hashBufferSize, "hash-buffer-size", defaultHashBufferSize, "Read buffer size in bytes used to hash -body-file.")
+	fs.StringVar(&opts.ifMatch, "if-match", "", "ETag sent as a signed If-Match header for optimistic concurrency writes, a 412 response sets the precondition_failed error.")
+	fs.BoolVar(&opts.imdsRegion, "imds-region", false, "Read the region from the EC2 instance metadata service when it is neither given nor guessed from the URL, for self-hosted runners on EC2.")
+	fs.StringVar(&opts.duplicateHeaders, "duplicate-headers", duplicateHeadersMerge, "How a header given more than once in -headers, whatever its case, is sent: merge (values joined by commas) or last (last value wins).")
+	fs.StringVar(&opts.notifyURL, "notify-url", "", "Webhook posted a JSON summary of the result once the request is done, success or failure. Best-effort, its failures are only reported.")
+	fs.StringVar(&opts.notifyFormat, "notify-format", notifyFormatJSON, "Payload posted to -notify-url: json, or slack for a {\"text\": ...} message to chat incoming webhooks.")
+	fs.StringVar(&opts.requestFile, "request-file", "", "File holding the request in HTTP message format (request line, headers, blank line, body), sent to the host of -lambda-url.")
+	fs.IntVar(&opts.maxHeaderBytes, "max-header-bytes", defaultMaxHeaderBytes, "Maximum size in bytes of the request headers, on their own and in total, before signing. 0 disables the check.")
+	fs.BoolVar(&opts.responseBase64, "response-base64", false, "Base64 encode the response body in the message output, for binary content. Sets the body_encoding output to base64.")
+	fs.IntVar(&opts.retries, "retries", 0, "Number of times the request is sent again after a connection error or a status of -retry-on-status, signed anew every time.")
+	fs.StringVar(&opts.retryBackoff, "retry-backoff", "1s", "Wait before the first retry as a duration (e.g. 500ms, 2s), doubled before each of the next ones. A Retry-After header of the response sets the wait instead, up to 2m.")
+	fs.StringVar(&opts.retryOnStatus, "retry-on-status", defaultRetryOnStatus, "Comma-separated statuses and ranges retried with -retries (e.g. 408,429,500-504).")
+	fs.StringVar(&opts.unixSocket, "unix-socket", "", "Path of a Unix domain socket the request is sent through (e.g. LocalStack or a sidecar), still signed for the host of -lambda-url.")
+	fs.StringVar(&opts.totalTimeout, "total-timeout", "", "Overall deadline of the request and its -retries as a duration (e.g. 2m), a retry whose backoff would not fit is skipped. Unset, only -timeout bounds each attempt.")
+	fs.BoolVar(&opts.anonymous, "anonymous", false, "Send the request unsigned, without credentials, for public function URLs (AuthType NONE).")
+	fs.BoolVar(&opts.pretty, "pretty", false, "Indent JSON responses printed in the logs, the default on a terminal. The message output keeps the raw body.")
+	fs.StringVar(&opts.rawBodyFile, "raw-body-file", "", "Path of a file sent byte for byte as the body, without Content-Type detection nor validation, signed with the SHA-256 of its exact bytes.")
+	fs.StringVar(&opts.harFile, "har-file", "", "Path of an HTTP Archive (HAR) written with the request, the response and their timings, for browser devtools and support tickets. Credentials are redacted.")
+	fs.StringVar(&opts.emitSignedHeaders, "emit-signed-headers", "", "Sign the request without sending it and write its headers to the request_headers output, as json or lines (Name: value), for another client to send it.")
+	fs.BoolVar(&opts.propagateTrace, "propagate-trace", false, "Send the tracing context of the TRACEPARENT, TRACESTATE and _X_AMZN_TRACE_ID env variables as traceparent, tracestate and X-Amzn-Trace-Id headers.")
+	fs.StringVar(&opts.maxLatency, "max-latency", "", "Fail with error=latency_exceeded when the request takes longer than this duration (e.g. 800ms), whatever the status, as a latency gate.")
+	fs.StringVar(&opts.sessionTokenFile, "session-token-file", "", "File holding the session token, e.g. written by an OIDC flow, used instead of the AWS_SESSION_TOKEN env variable.")
+	fs.StringVar(&opts.preRequestHook, "pre-request-hook", "", "Executable given the request (method, url, headers, body) as JSON on stdin, returning the request to sign as JSON on stdout, e.g. to inject headers.")
+	fs.IntVar(&opts.maxOutputBytes, "max-output-bytes", defaultMaxOutputBytes, "Maximum size in bytes of the message output, longer bodies are cut with a ...[truncated] marker and the truncated output set to true. 0 disables the limit.")
+	fs.StringVar(&opts.outputFile, "output-file", "", "Path of a file the whole response body is written to as received, even when the message output is truncated. Combines with -output-fd and -metrics-file, the body is read once for all of them.")
+	fs.BoolVar(&opts.pathStyle, "path-style", false, "Send S3 requests path-style (s3.<region>.amazonaws.com/bucket/key), rewriting a virtual-hosted URL (bucket.s3.<region>.amazonaws.com/key). Requires -service s3.")
+	fs.StringVar(&opts.metricsFile, "metrics-file", "", "Path of a file the request_duration_seconds, response_code and retries_total metrics of the request are written to in the Prometheus text format.")
+	fs.BoolVar(&opts.noCredentialCheck, "no-credential-validation", false, "Do not warn when AWS_ACCESS_KEY_ID does not look like an access key ID.")
+	fs.Var((*stringList)(&opts.bodyFiles), "body-file", "Path of a file sent as the body, streamed from disk so large payloads are not held in memory. Can be repeated, the files are then sent one after the other in the order given, as a single body.")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "Print the resolved configuration (URL, method, region, service, timeouts, retries, header names, credential source) as JSON to stderr before sending the request, secrets left out.")
+	fs.StringVar(&opts.expectContentType, "expect-content-type", "", "Fail with error=content_type_mismatch unless the response Content-Type matches: the media type (application/json), the whole value when it has parameters, or a prefix ending with * (text/*).")
+	fs.BoolVar(&opts.certRegion, "cert-region", false, "Best effort, read the region from the names of the certificate served for the URL when it is neither given nor guessed, for custom domains whose certificate also covers an AWS name.")
+	fs.BoolVar(&opts.trimBody, "trim-body", false, "Trim the trailing whitespace of -body or of the body of -request-file, such as the newline added by YAML block scalars, before hashing and sending it.")
+	fs.StringVar(&opts.attemptLog, "attempt-log", "", "Path of a JSONL file a line is appended to for every attempt (time, status or error, duration), retries included, for post-mortem analysis of flaky endpoints.")
+	fs.StringVar(&opts.ifUnmodifiedSince, "if-unmodified-since", "", "Time sent as a signed If-Unmodified-Since header to guard a write, as an HTTP date such as a previous Last-Modified or RFC 3339, a 412 response sets the precondition_failed error.")
+	fs.StringVar(&opts.connectTimeout, "connect-timeout", "", "Timeout of the TCP connect phase alone as a duration (e.g. 2s), to fail fast on an unreachable endpoint while -timeout allows a long response. Defaults to 30s.")
+	fs.BoolVar(&opts.disableKeepAlive, "disable-keepalive", false, "Open a new connection for every request rather than reusing one, for proxies mishandling connection reuse or to time the TLS handshake of every request.")
+	fs.StringVar(&opts.dnsServer, "dns-server", "", "DNS server (host or host:port, port 53 by default) resolving the host of the URL instead of the system resolver, for split-horizon setups with private endpoints.")
+	fs.StringVar(&opts.responseSchema, "response-schema", "", "Path of a JSON Schema a JSON response is validated against, failing with error=schema_mismatch and the violations in the schema_errors output when it does not match. A subset of JSON Schema is supported: type, enum, const, the numeric, string, array and object constraints, allOf, anyOf, oneOf, not and local $ref; a schema using any other keyword (if, contains, dependentRequired...) is rejected, annotations such as format or description are ignored.")
+	fs.StringVar(&opts.invocationType, "invocation-type", "", "Lambda invocation type sent as a signed X-Amz-Invocation-Type header: RequestResponse, Event (asynchronous, answered 202 Accepted once queued) or DryRun.")
+	fs.Int64Var(&opts.maxBodyBytes, "max-body-bytes", defaultMaxBodyBytes, "Maximum size in bytes of -body-file (all the files together) or -raw-body-file, checked from the file sizes before anything is read to fail fast on a wrong path. 0 disables the check.")
+	fs.BoolVar(&opts.refreshCredentials, "refresh-credentials", false, "Read the credentials again before signing every attempt (retries, redirects, -repeat) for long runs whose temporary credentials rotate, e.g. a -session-token-file rewritten by an OIDC flow or a -credentials-process. Static env credentials are used as is.")
+	fs.StringVar(&opts.goldenFile, "golden-file", "", "Path of a file holding the expected response body, failing with error=golden_mismatch and the unified diff in the golden_diff output when the body differs, for API snapshot tests.")
+	fs.BoolVar(&opts.goldenJSON, "golden-json", false, "Compare the response with -golden-file as JSON, regardless of field order and whitespace.")
+	fs.StringVar(&opts.hashBodyMethods, "hash-body-methods", "", "Comma-separated methods whose body is covered by the payload hash, defaults to "+defaultHashBodyMethods+" (and GET with -allow-get-body). The body of other methods is sent but signed as an empty payload, for proxies stripping it.")
+	fs.StringVar(&opts.profiles, "profiles", "", "Comma-separated profiles of the shared credentials file (AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials), the request is signed and sent once per profile and the results reported by profile, for multi-account smoke tests.")
+	fs.StringVar(&opts.successOnBodyRegex, "success-on-body-regex", "", "Regular expression marking the run successful when the response body matches, for idempotent creates answered with an error such as \"already exists\". It takes precedence over -fail-on-error, a 4xx or 5xx response whose body matches does not fail, but not over the -expect-header, -expect-content-type, -response-schema, -golden-file and -max-latency checks.")
+	fs.StringVar(&opts.openAPIExampleFile, "openapi-example-file", "", "Path of a JSON OpenAPI 3 fragment written with the request and its response as examples of the operation (parameters, request body and response), to generate API docs from real signed invocations. Credentials are left out.")
+	fs.StringVar(&opts.defaultHeadersFile, "default-headers-file", "", "File of \"Name: value\" lines (# comments allowed) added to the request under -headers and the headers of -request-file, which win on conflict whatever the case of the name, for org-wide defaults such as X-Source: ci.")
+	fs.StringVar(&opts.credentialsProcess, "credentials-process", "", "Command printing the credentials to sign with as credential_process JSON (Version 1, AccessKeyId, SecretAccessKey, optional SessionToken and Expiration), like the AWS CLI credential_process, instead of the env variables. Run again before every attempt with -refresh-credentials.")
+	fs.BoolVar(&opts.strict, "strict", false, "Fail the run, after the outputs are written, when a deprecated behavior is triggered: the ::set-output command written without GITHUB_OUTPUT, or a reserved header (Authorization, X-Amz-Date, X-Amz-Security-Token) given that the signature replaces.")
+	fs.StringVar(&opts.jq, "jq", "", "jq program transforming a JSON response into the message output, run by gojq without access to the environment (env and $ENV are empty). Strings are written raw, several results one per line. A response that is not JSON keeps the body as is, with a warning.")
+	fs.StringVar(&opts.assumeRoleARN, "assume-role-arn", "", "ARN of a role assumed with sts:AssumeRole before sending, the request is signed with the temporary credentials of the role instead of the credentials given.")
+	fs.StringVar(&opts.roleSessionName, "role-session-name", defaultRoleSessionName, "Session name of -assume-role-arn, shown in CloudTrail.")
+	fs.StringVar(&opts.externalID, "external-id", "", "External ID passed to sts:AssumeRole, for roles whose trust policy requires one. Requires -assume-role-arn.")
+	fs.Var((*stringList)(&opts.roleTags), "role-tags", "Session tag as key=value passed to sts:AssumeRole, for roles granting access on principal tags. Can be repeated. Requires -assume-role-arn.")
+	fs.StringVar(&opts.debugBundle, "debug-bundle", "", "Path of a zip written when the run fails, to attach to a support ticket: the resolved configuration, the signed request, the canonical request and string to sign, the response as HAR, the timings and the log. Credentials are redacted.")
+	fs.Var((*stringList)(&opts.dataURLEncode), "data-urlencode", "Form field as name=value (like curl --data-urlencode), the value is URL-encoded and appended to a form-encoded body. Can be repeated.")
+	fs.Var((*stringList)(&opts.query), "query", "Query parameter as name=value added to the URL, encoded like SigV4 canonical query strings (RFC 3986, a space as %20) so the signature matches what the server computes. Can be repeated.")
+	fs.Var((*stringList)(&opts.expectHeaders), "expect-header", "Response header required for success as name=value (exact) or name~=regexp, failing the action otherwise. Can be repeated, every expectation must hold.")
+	fs.Var(formFlag{parts: &opts.formParts}, "form", "Multipart form field as name=value (like curl --form). Can be repeated and mixed with -form-file, order is preserved.")
+	fs.Var(formFlag{parts: &opts.formParts, file: true}, "form-file", "Multipart file part as field=@path (like curl --form). Can be repeated and mixed with -form, order is preserved.")
+	err := fs.Parse(args)
+	return opts, err
+}
 
-	var credentials aws.Credentials
+// run sends the signed request and writes the outputs, returning the exit
+// code of the action instead of exiting. env holds the environment variables,
+// the package logger is replaced by one writing to stdout and stderr. The
+// phases of the run are the methods of runner, a setup phase fails the run
+// with the error it returns.
+func run(args []string, env map[string]string, stdout, stderr io.Writer) (code int) {
+	logger = newLogger(logFormatText, false, stdout, stderr)
+	opts, err := parseFlags(args, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
 
-	if *lambdaURL == "" {
-		fmt.Fprintln(os.Stderr, "lambda-url is required")
-		os.Exit(1)
+	format, err := parseLogFormat(opts.logFormat)
+	if err != nil {
+		logger.Error(err.Error(), nil)
+		return 1
 	}
+	logger = newLogger(format, opts.verbose, stdout, stderr)
+	var bundle *debugBundle
+	if opts.debugBundle != "" {
+		bundle = newDebugBundle(time.Now())
+		logger = newLogger(format, opts.verbose, io.MultiWriter(stdout, &bundle.log), io.MultiWriter(stderr, &bundle.log))
+		// Registered first to run last, once -strict had its say on the exit code
+		defer func() {
+			if code == 0 {
+				return
+			}
+			if err := bundle.write(opts.debugBundle, time.Now()); err != nil {
+				logger.Warn(fmt.Sprintf("error writing the debug bundle %s", err), nil)
+				return
+			}
+			logger.Info("debug bundle written, attach it to a support ticket", logFields{"path": opts.debugBundle})
+		}()
+	}
+	if opts.strict {
+		// Checked last, the outputs written at the very end may be deprecated
+		defer func() {
+			if deprecations := logger.deprecations(); code == 0 && len(deprecations) > 0 {
+				logger.Error(fmt.Sprintf("-strict: %d deprecated behaviors triggered: %s", len(deprecations), strings.Join(deprecations, "; ")), nil)
+				code = 1
+			}
+		}()
+	}
+
+	r := &runner{opts: opts, env: env, stdout: stdout, stderr: stderr, bundle: bundle}
+	for _, phase := range []func() error{r.parseSettings, r.resolveSigning, r.prepareRequest, r.setupClient} {
+		if err := phase(); err != nil {
+			logger.Error(err.Error(), nil)
+			return 1
+		}
+	}
+	if opts.repeat > 1 {
+		return r.repeatRequests()
+	}
+	if len(r.profiles) > 0 {
+		return r.profileRequests()
+	}
+	return r.singleRequest()
+}
+
+// runner carries a run from one phase to the next: the options and the
+// environment, then what every phase resolves for the following ones.
+type runner struct {
+	opts           options
+	env            map[string]string
+	stdout, stderr io.Writer
+	bundle         *debugBundle
+
+	// Parsed from the flags by parseSettings
+	specHeader         http.Header
+	profileNames       []string
+	roleInput          assumeRoleInput
+	connectTimeout     time.Duration
+	dnsServer          string
+	requestTimeout     time.Duration
+	skew               time.Duration
+	signingTime        time.Time
+	ifUnmodifiedSince  time.Time
+	invocationType     string
+	dateHeader         string
+	retryBackoff       time.Duration
+	retryStatuses      statusSet
+	totalTimeout       time.Duration
+	maxLatency         time.Duration
+	headerExpectations []headerExpectation
+	responseSchema     *jsonSchema
+	golden             *goldenFile
+	successPattern     *regexp.Regexp
+	jqTransform        *jqProgram
+
+	// Resolved by resolveSigning, an anonymous run has none
+	awsRegion        string
+	credentials      aws.Credentials
+	credentialSource string
+	refreshed        *refreshedCredentials
+	profiles         []profileCredentials
 
-	awsRegion := os.Getenv(EnvAWSRegion)
+	// Built by prepareRequest
+	method          string
+	body            string
+	bodyContentType string
+	file            *fileBody
+	hashBodyMethods map[string]bool
+	requestHeaders  http.Header
+	cache           *responseCache
+
+	// Created by setupClient
+	signer    *v4.Signer
+	transport *wireTransport
+	client    *http.Client
+	redirects *redirectPolicy
+}
+
+// parseSettings validates the flags and parses their values, a mistake fails
+// the run before anything is read or sent.
+func (r *runner) parseSettings() error {
+	opts := &r.opts
 	var err error
-	if awsRegion == "" {
-		fmt.Fprintln(os.Stdout, "AWS region is not specified, try to guess from lambda URL")
-		// Try to extract region from function URL => https://<id>.lambda-url.<region>.on.aws/
-		awsRegion, err = guessAWSRegion(*lambdaURL)
+	if opts.lambdaURL == "" {
+		return errors.New("lambda-url is required")
+	}
+
+	// Method, path, headers and body of a raw request fixture, signed for the
+	// authority of -lambda-url
+	if opts.requestFile != "" {
+		if opts.body != "" || len(opts.bodyFiles) > 0 || opts.rawBodyFile != "" || len(opts.dataURLEncode) > 0 || len(opts.formParts) > 0 {
+			return errors.New("-request-file cannot be combined with -body, -body-file, -raw-body-file, -data-urlencode or -form")
+		}
+		spec, err := readRequestSpec(opts.requestFile)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			os.Exit(1)
+			return err
+		}
+		if opts.lambdaURL, err = spec.resolve(opts.lambdaURL); err != nil {
+			return err
+		}
+		// The request line takes precedence over -method, which a shared
+		// workflow may set for all its calls
+		if opts.method != "" && !strings.EqualFold(opts.method, spec.Method) {
+			logger.Warn(fmt.Sprintf("-method %s is overridden by the %s method of -request-file", opts.method, spec.Method), nil)
+		}
+		opts.method = spec.Method
+		opts.body = spec.Body
+		r.specHeader = spec.Header
+	}
+
+	if opts.pathStyle {
+		if opts.service != serviceS3 {
+			return errors.New("-path-style requires -service s3")
+		}
+		u, err := url.Parse(opts.lambdaURL)
+		if err != nil {
+			return fmt.Errorf("invalid lambda-url %s", err)
+		}
+		s3PathStyle(u)
+		opts.lambdaURL = u.String()
+	}
+
+	if len(opts.query) > 0 {
+		if opts.lambdaURL, err = appendQuery(opts.lambdaURL, opts.query); err != nil {
+			return err
 		}
 	}
 
-	awsAccessKeyID := os.Getenv(EnvAWSAccessKeyID)
-	if awsAccessKeyID == "" {
-		fmt.Fprintf(os.Stderr, "%s env variable is required\n", EnvAWSAccessKeyID)
-		os.Exit(1)
+	// Flags describing a single request and its response
+	singleRequestFlags := []repeatFlag{
+		{"output-fd", opts.outputFD != ""},
+		{"trace", opts.trace},
+		{"tls-info", opts.tlsInfo},
+		{"expected-signature", opts.expectedSignature != ""},
+		{"retries", opts.retries > 0},
+		{"har-file", opts.harFile != ""},
+		{"emit-signed-headers", opts.emitSignedHeaders != ""},
+		{"output-file", opts.outputFile != ""},
+		{"metrics-file", opts.metricsFile != ""},
+		{"attempt-log", opts.attemptLog != ""},
+		{"success-on-body-regex", opts.successOnBodyRegex != ""},
+		{"openapi-example-file", opts.openAPIExampleFile != ""},
+	}
+	if opts.repeat > 1 {
+		if err := checkRepeatFlags(singleRequestFlags); err != nil {
+			return err
+		}
 	}
 
-	awsSecretAccessKey := os.Getenv(EnvAWSSecretAccessKey)
-	if awsSecretAccessKey == "" {
-		fmt.Fprintf(os.Stderr, "%s env variable is required\n", EnvAWSSecretAccessKey)
-		os.Exit(1)
+	if opts.profiles != "" {
+		err = checkModeFlags("profiles", append(singleRequestFlags, []repeatFlag{
+			{"repeat", opts.repeat > 1},
+			{"anonymous", opts.anonymous},
+			{"session-token-file", opts.sessionTokenFile != ""},
+			{"credentials-process", opts.credentialsProcess != ""},
+			{"assume-role-arn", opts.assumeRoleARN != ""},
+			{"refresh-credentials", opts.refreshCredentials},
+			{"verify-credentials", opts.verifyCredentials},
+			{"golden-file", opts.goldenFile != ""},
+			{"response-schema", opts.responseSchema != ""},
+			{"expect-header", len(opts.expectHeaders) > 0},
+			{"expect-content-type", opts.expectContentType != ""},
+			{"max-latency", opts.maxLatency != ""},
+		}...))
+		if err == nil {
+			r.profileNames, err = parseProfileList(opts.profiles)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	awsSessionToken := os.Getenv(EnvAWSSessionToken)
-	if awsSessionToken == "" {
-		credentials = aws.Credentials{AccessKeyID: awsAccessKeyID, SecretAccessKey: awsSecretAccessKey}
+	if opts.credentialsProcess != "" {
+		// The process provides the session token along with the keys
+		err = checkModeFlags("credentials-process", []repeatFlag{
+			{"anonymous", opts.anonymous},
+			{"session-token-file", opts.sessionTokenFile != ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.assumeRoleARN != "" {
+		// The role credentials are assumed once, a refresh would sign with the base ones
+		err = checkModeFlags("assume-role-arn", []repeatFlag{
+			{"anonymous", opts.anonymous},
+			{"refresh-credentials", opts.refreshCredentials},
+		})
+		if err == nil && opts.roleSessionName == "" {
+			err = fmt.Errorf("-role-session-name cannot be empty with -assume-role-arn")
+		}
+		if err == nil {
+			r.roleInput = assumeRoleInput{RoleARN: opts.assumeRoleARN, SessionName: opts.roleSessionName, ExternalID: opts.externalID}
+			r.roleInput.Tags, err = parseRoleTags(opts.roleTags)
+		}
+	} else if opts.externalID != "" || len(opts.roleTags) > 0 {
+		err = fmt.Errorf("-external-id and -role-tags require -assume-role-arn")
+	}
+	if err != nil {
+		return err
+	}
+
+	r.connectTimeout, err = parseDurationFlag("connect-timeout", opts.connectTimeout)
+	if err != nil {
+		return err
+	}
+
+	r.dnsServer, err = dnsServerAddress(opts.dnsServer)
+	if err != nil {
+		return err
+	}
+
+	r.requestTimeout, err = parseDurationFlag("timeout", opts.timeout)
+	if err != nil {
+		return err
+	}
+
+	r.skew, err = parseOffsetFlag("clock-skew", opts.clockSkew)
+	if err != nil {
+		return err
+	}
+
+	r.signingTime, err = parseTimeFlag("signing-time", opts.signingTime)
+	if err != nil {
+		return err
+	}
+
+	r.ifUnmodifiedSince, err = parseHTTPDateFlag("if-unmodified-since", opts.ifUnmodifiedSince)
+	if err != nil {
+		return err
+	}
+
+	r.invocationType, err = parseInvocationType(opts.invocationType)
+	if err != nil {
+		return err
+	}
+
+	r.dateHeader, err = parseDateHeader(opts.dateHeader)
+	if err != nil {
+		return err
+	}
+
+	if opts.maxBodyBytes < 0 {
+		return fmt.Errorf("invalid -max-body-bytes %d, must not be negative", opts.maxBodyBytes)
+	}
+
+	if opts.maxOutputBytes < 0 {
+		return fmt.Errorf("invalid -max-output-bytes %d, must not be negative", opts.maxOutputBytes)
+	}
+
+	if opts.retries < 0 {
+		return fmt.Errorf("invalid number of retries %d, must not be negative", opts.retries)
+	}
+	r.retryBackoff, err = parseDurationFlag("retry-backoff", opts.retryBackoff)
+	if err != nil {
+		return err
+	}
+	r.retryStatuses, err = parseStatusList("retry-on-status", opts.retryOnStatus)
+	if err != nil {
+		return err
+	}
+	r.totalTimeout, err = parseDurationFlag("total-timeout", opts.totalTimeout)
+	if err != nil {
+		return err
+	}
+	r.maxLatency, err = parseDurationFlag("max-latency", opts.maxLatency)
+	if err != nil {
+		return err
+	}
+	for _, e := range opts.expectHeaders {
+		expectation, err := parseHeaderExpectation(e)
+		if err != nil {
+			return err
+		}
+		r.headerExpectations = append(r.headerExpectations, expectation)
+	}
+
+	if opts.responseSchema != "" {
+		if opts.outputFD != "" {
+			return errors.New("-response-schema cannot be combined with -output-fd, the body is streamed without being read")
+		}
+		if r.responseSchema, err = loadJSONSchema(opts.responseSchema); err != nil {
+			return err
+		}
+	}
+
+	if opts.goldenFile != "" {
+		if opts.outputFD != "" {
+			return errors.New("-golden-file cannot be combined with -output-fd, the body is streamed without being read")
+		}
+		if r.golden, err = loadGoldenFile(opts.goldenFile, opts.goldenJSON); err != nil {
+			return err
+		}
+	} else if opts.goldenJSON {
+		return errors.New("-golden-json requires -golden-file")
+	}
+
+	if opts.openAPIExampleFile != "" && opts.outputFD != "" {
+		return errors.New("-openapi-example-file cannot be combined with -output-fd, the body is streamed without being read")
+	}
+
+	if opts.successOnBodyRegex != "" {
+		if opts.outputFD != "" {
+			return errors.New("-success-on-body-regex cannot be combined with -output-fd, the body is streamed without being read")
+		}
+		if r.successPattern, err = regexp.Compile(opts.successOnBodyRegex); err != nil {
+			return fmt.Errorf("invalid -success-on-body-regex %s", err)
+		}
+	}
+
+	if opts.jq != "" {
+		if opts.outputFD != "" || opts.responseBase64 {
+			return errors.New("-jq cannot be combined with -output-fd or -response-base64, the message output must be the JSON body")
+		}
+		if r.jqTransform, err = parseJQ(opts.jq); err != nil {
+			return err
+		}
+	}
+
+	if opts.emitSignedHeaders != "" {
+		if err := checkSignedHeadersFormat(opts.emitSignedHeaders); err != nil {
+			return err
+		}
+		if opts.anonymous {
+			return errors.New("-emit-signed-headers cannot be combined with -anonymous")
+		}
+	}
+
+	if opts.notifyURL != "" {
+		if _, err := (notification{}).payload(opts.notifyFormat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveSigning resolves the region and the credentials the request is
+// signed with.
+func (r *runner) resolveSigning() error {
+	opts, env := &r.opts, r.env
+	var err error
+	r.credentialSource = credentialSourceNone
+	// Public function URLs (AuthType NONE) take the request as is
+	if opts.anonymous {
+		if opts.verifyCredentials || opts.expectedSignature != "" {
+			return errors.New("-anonymous cannot be combined with -verify-credentials or -expected-signature")
+		}
+	} else {
+		if region, ok := globalServiceRegions[opts.service]; ok && normalizeRegion(opts.region) == "" {
+			// AWS_REGION is meant for the regional services of the job
+			r.awsRegion = region
+			logger.Debug("global service signed in its own region", logFields{"service": opts.service, "region": r.awsRegion})
+		} else {
+			r.awsRegion, err = resolveRegion(opts.region, env[EnvAWSRegion], opts.lambdaURL, !opts.noGuessRegion)
+		}
+		if err != nil && opts.imdsRegion {
+			// Self-hosted runners on EC2 know their region without any configuration
+			var imdsErr error
+			r.awsRegion, imdsErr = imdsRegion(context.Background(), newIMDSClient(), env[EnvIMDSEndpoint])
+			if imdsErr != nil {
+				err = fmt.Errorf("%s, and the instance metadata service is unavailable: %s", err, imdsErr)
+			} else {
+				err = nil
+				logger.Info("AWS region read from the instance metadata service", logFields{"region": r.awsRegion})
+			}
+		}
+		if err != nil && opts.certRegion {
+			// Custom domains hide the region, their certificate may still name it
+			var certErr error
+			r.awsRegion, certErr = certificateRegion(context.Background(), opts.lambdaURL, nil)
+			if certErr != nil {
+				err = fmt.Errorf("%s, and %s", err, certErr)
+			} else {
+				err = nil
+				logger.Info("AWS region read from the served certificate", logFields{"region": r.awsRegion})
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(r.profileNames) > 0 {
+			// The env credentials are not used, every request is signed by a profile
+			path, err := sharedCredentialsFile(env)
+			if err == nil {
+				r.profiles, err = loadProfiles(path, r.profileNames)
+			}
+			if err != nil {
+				return err
+			}
+			for _, profile := range r.profiles {
+				if !opts.noCredentialCheck {
+					if err := checkAccessKeyID(profile.credentials.AccessKeyID); err != nil {
+						logger.Warn(fmt.Sprintf("profile %s: %s, expect the request to be rejected with a 403", profile.name, err), nil)
+					}
+				}
+				logger.addSecret(profile.credentials.SecretAccessKey)
+				logger.addSecret(profile.credentials.SessionToken)
+			}
+			r.credentials, r.credentialSource = r.profiles[0].credentials, credentialSourceProfiles
+		} else {
+			var process *credentialsProcess
+			if opts.credentialsProcess != "" {
+				var p credentialsProcess
+				p, err = newCredentialsProcess(opts.credentialsProcess, env)
+				if err == nil {
+					process = &p
+					r.credentials, err = p.Retrieve(context.Background())
+				}
+				r.credentialSource = credentialSourceProcess
+			} else {
+				r.credentials, err = envCredentials(env)
+				r.credentialSource = credentialSourceEnv
+			}
+			if err != nil {
+				return err
+			}
+			if !opts.noCredentialCheck {
+				// Only a warning, AWS may well introduce new formats
+				if err := checkAccessKeyID(r.credentials.AccessKeyID); err != nil {
+					logger.Warn(fmt.Sprintf("%s, expect the request to be rejected with a 403, check the credentials configuration", err), nil)
+				}
+			}
+			if process != nil && opts.refreshCredentials {
+				r.refreshed = &refreshedCredentials{provider: *process, last: r.credentials}
+			}
+		}
+		if opts.sessionTokenFile != "" {
+			if r.credentials.SessionToken, err = readSessionToken(opts.sessionTokenFile); err != nil {
+				return err
+			}
+			r.credentialSource = credentialSourceTokenFile
+		}
+		logger.addSecret(r.credentials.SecretAccessKey)
+		logger.addSecret(r.credentials.SessionToken)
+		if opts.refreshCredentials {
+			if r.credentialSource == credentialSourceTokenFile {
+				r.refreshed = &refreshedCredentials{provider: tokenFileProvider{r.credentials, opts.sessionTokenFile}, last: r.credentials}
+			} else if r.credentialSource != credentialSourceProcess {
+				logger.Warn("-refresh-credentials only applies to -session-token-file and -credentials-process, the env credentials cannot change during the run", nil)
+			}
+		}
+		if opts.assumeRoleARN != "" {
+			r.credentialSource += credentialSourceAssumeRole
+		}
+	}
+	return nil
+}
+
+// prepareRequest builds the method, body and headers of the request from the
+// flags, the request file and the pre-request hook.
+func (r *runner) prepareRequest() error {
+	opts, env := &r.opts, r.env
+	var err error
+	r.body = opts.body
+	if opts.trimBody {
+		r.body = strings.TrimRight(r.body, " \t\r\n")
+	}
+	// Content-Type implied by the form flags, an explicit header wins except
+	// for multipart where the boundary must match the body
+	r.method = resolveMethod(opts.method, len(opts.dataURLEncode) > 0 || len(opts.formParts) > 0)
+	if len(opts.dataURLEncode) > 0 {
+		r.body, err = appendURLEncodedData(r.body, opts.dataURLEncode)
+		if err != nil {
+			return err
+		}
+		r.bodyContentType = formURLEncoded
+	}
+	if len(opts.formParts) > 0 {
+		if opts.body != "" || len(opts.dataURLEncode) > 0 {
+			return errors.New("-form and -form-file cannot be combined with -body or -data-urlencode")
+		}
+		r.body, r.bodyContentType, err = buildMultipartBody(opts.formParts, "", opts.detectContentType)
+		if err != nil {
+			return err
+		}
+	}
+	// A large file is streamed from disk instead of being read in body
+	if len(opts.bodyFiles) > 0 {
+		if r.body != "" {
+			return errors.New("-body-file cannot be combined with -body, -data-urlencode or -form")
+		}
+		if err := checkBodyFilesSize("body-file", opts.bodyFiles, opts.maxBodyBytes); err != nil {
+			return err
+		}
+		r.file, err = newFileBody(opts.bodyFiles, opts.hashBufferSize)
+		if err != nil {
+			return fmt.Errorf("error trying to read -body-file %s", err)
+		}
+	}
+	// The escape hatch when any transformation would break the signature, the
+	// bytes are neither inspected nor converted
+	if opts.rawBodyFile != "" {
+		if r.body != "" || r.file != nil {
+			return errors.New("-raw-body-file cannot be combined with -body, -body-file, -data-urlencode or -form")
+		}
+		if err := checkBodyFilesSize("raw-body-file", []string{opts.rawBodyFile}, opts.maxBodyBytes); err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(opts.rawBodyFile)
+		if err != nil {
+			return fmt.Errorf("error trying to read -raw-body-file %s", err)
+		}
+		r.body = string(data)
+	}
+	if r.method == http.MethodGet && (r.body != "" || r.file != nil) && !opts.allowGetBody {
+		// GET bodies have no defined semantics, proxies and most services drop them
+		logger.Warn("the body is not sent with a GET request, use -allow-get-body to send and sign it anyway", nil)
+		r.body, r.bodyContentType, r.file = "", "", nil
+	}
+	hashBodyList := opts.hashBodyMethods
+	if hashBodyList == "" {
+		hashBodyList = defaultHashBodyMethods
+		if opts.allowGetBody {
+			hashBodyList += "," + http.MethodGet
+		}
+	}
+	r.hashBodyMethods, err = parseMethodList("hash-body-methods", hashBodyList)
+	if err != nil {
+		return err
+	}
+
+	var lookupEnv func(string) (string, bool)
+	if opts.interpolateHeaders {
+		lookupEnv = func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		}
+	}
+	// Parsed once so -repeat does not report the same header issues on every request
+	// -headers takes precedence over the request file, both over the defaults
+	parsedHeaders := mergeHeaders(parseHeaders(opts.headers, lookupEnv), r.specHeader)
+	if opts.defaultHeadersFile != "" {
+		defaultHeaders, err := readDefaultHeaders(opts.defaultHeadersFile, lookupEnv)
+		if err != nil {
+			return err
+		}
+		parsedHeaders = mergeHeaders(parsedHeaders, defaultHeaders)
+	}
+	r.requestHeaders, err = dedupeHeaders(parsedHeaders, opts.duplicateHeaders)
+	if err != nil {
+		return err
+	}
+	if !opts.anonymous {
+		for _, name := range reservedHeaders {
+			if _, ok := r.requestHeaders[name]; ok {
+				logger.Deprecated(fmt.Sprintf("header %s is reserved, the value given is replaced by the signature", name), nil)
+			}
+		}
+	}
+	if opts.propagateTrace {
+		propagateTraceHeaders(r.requestHeaders, env)
+	}
+	if opts.accept != "" {
+		r.requestHeaders.Set("Accept", opts.accept)
+	}
+	if opts.ifMatch != "" {
+		r.requestHeaders.Set("If-Match", opts.ifMatch)
+	}
+	if !r.ifUnmodifiedSince.IsZero() {
+		r.requestHeaders.Set("If-Unmodified-Since", r.ifUnmodifiedSince.Format(http.TimeFormat))
+	}
+	if r.invocationType != "" {
+		if value := r.requestHeaders.Get(invocationTypeHeader); value != "" && value != r.invocationType {
+			return fmt.Errorf("-invocation-type %s conflicts with the %s: %s header", r.invocationType, invocationTypeHeader, value)
+		}
+		r.requestHeaders.Set(invocationTypeHeader, r.invocationType)
+	}
+	if err := checkHeaderSize(r.requestHeaders, opts.maxHeaderBytes); err != nil {
+		return err
+	}
+	if opts.detectContentType && opts.rawBodyFile == "" && r.bodyContentType == "" && (r.body != "" || r.file != nil) && r.requestHeaders.Get("Content-Type") == "" {
+		sample := []byte(r.body)
+		if r.file != nil {
+			if sample, err = r.file.head(); err != nil {
+				return fmt.Errorf("error trying to read -body-file %s", err)
+			}
+		}
+		r.bodyContentType = detectContentType(sample)
+		logger.Debug("detected the Content-Type of the body", logFields{"content_type": r.bodyContentType})
+	}
+
+	if opts.validateBody && r.file != nil {
+		logger.Warn("-validate-body is not applied to -body-file, the file is streamed without being parsed", nil)
+	} else if opts.validateBody && opts.rawBodyFile != "" {
+		logger.Warn("-validate-body is not applied to -raw-body-file, the file is sent without being parsed", nil)
+	} else if opts.validateBody {
+		contentType := r.requestHeaders.Get("Content-Type")
+		if contentType == "" || len(opts.formParts) > 0 {
+			contentType = r.bodyContentType
+		}
+		if err := validateBody(contentType, r.body); err != nil {
+			return err
+		}
+	}
+
+	// Augmented by a script, the request it returns is the one signed
+	if opts.preRequestHook != "" {
+		if r.file != nil || opts.rawBodyFile != "" {
+			return errors.New("-pre-request-hook cannot be combined with -body-file or -raw-body-file")
+		}
+		if r.bodyContentType != "" && (r.requestHeaders.Get("Content-Type") == "" || len(opts.formParts) > 0) {
+			r.requestHeaders.Set("Content-Type", r.bodyContentType)
+		}
+		r.bodyContentType = ""
+		hooked, err := runPreRequestHook(opts.preRequestHook, env, hookedRequest{r.method, opts.lambdaURL, r.requestHeaders, r.body})
+		if err != nil {
+			return err
+		}
+		r.method, opts.lambdaURL, r.requestHeaders, r.body = hooked.method, hooked.url, hooked.header, hooked.body
+		if err := checkHeaderSize(r.requestHeaders, opts.maxHeaderBytes); err != nil {
+			return err
+		}
+		logger.Debug("request changed by the pre-request hook", logFields{"method": r.method, "url": opts.lambdaURL, "headers": headerFields(r.requestHeaders)})
+	}
+
+	if !r.hashBodyMethods[r.method] && (r.body != "" || r.file != nil) {
+		logger.Warn(fmt.Sprintf("the body of the %s request is sent but signed as an empty payload, add %s to -hash-body-methods if the endpoint receives it", r.method, r.method), nil)
+	}
+
+	if opts.printConfig || r.bundle != nil {
+		config := resolvedConfig{
+			URL:              opts.lambdaURL,
+			Method:           r.method,
+			Region:           r.awsRegion,
+			Service:          opts.service,
+			Timeout:          r.requestTimeout.String(),
+			TotalTimeout:     r.totalTimeout.String(),
+			Retries:          opts.retries,
+			RetryOnStatus:    opts.retryOnStatus,
+			Headers:          headerNames(r.requestHeaders),
+			BodyBytes:        int64(len(r.body)),
+			CredentialSource: r.credentialSource,
+		}
+		if r.file != nil {
+			config.BodyBytes = r.file.size
+		}
+		if r.credentials.AccessKeyID != "" && len(r.profiles) == 0 {
+			config.AccessKeyID = maskAccessKeyID(r.credentials.AccessKeyID)
+		}
+		if r.bundle != nil {
+			r.bundle.setConfig(config)
+		}
+		if opts.printConfig {
+			if err := printConfig(r.stderr, config); err != nil {
+				logger.Warn(fmt.Sprintf("error printing the configuration %s", err), nil)
+			}
+		}
+	}
+
+	if opts.cache {
+		if r.method == http.MethodGet {
+			r.cache = newResponseCache(cacheMaxEntries)
+		} else {
+			logger.Warn(fmt.Sprintf("-cache only applies to GET requests, ignored for %s", r.method), nil)
+		}
+	}
+	return nil
+}
+
+// sign signs req with the payload hash bodyHash, again for every retry and
+// redirect hop. Anonymous requests are left as is.
+func (r *runner) sign(req *http.Request, bodyHash string) {
+	opts := &r.opts
+	if opts.anonymous {
+		return
+	}
+	if opts.service == serviceS3 {
+		prepareS3Request(req, bodyHash)
+	}
+	now := r.signingTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	// Runners with a broken clock sign with an offset rather than their local time
+	now = now.Add(r.skew)
+	if r.dateHeader != "" {
+		// Set before signing so the copy is covered by the signature too
+		req.Header.Set(r.dateHeader, now.UTC().Format(amzDateFormat))
+	}
+	signingCredentials := r.credentials
+	if r.refreshed != nil {
+		signingCredentials = r.refreshed.get(req.Context())
+	}
+	rawQuery := req.URL.RawQuery
+	r.signer.SignHTTP(context.Background(), signingCredentials, req, bodyHash, opts.service, r.awsRegion, now)
+	restoreQueryOrder(req, rawQuery)
+	if r.bundle != nil {
+		r.bundle.setRequest(req)
+	}
+}
+
+// signedRequest builds a signed request, returned along with the payload hash
+// it signed.
+func (r *runner) signedRequest() (*http.Request, string, error) {
+	opts := &r.opts
+	req, bodyHash, err := buildRequest(opts.lambdaURL, r.method, r.awsRegion, r.body)
+	if err != nil {
+		return nil, "", err
+	}
+	// In a fixed order so identical inputs make identical requests, and
+	// identical signatures with -signing-time
+	for _, name := range headerNames(r.requestHeaders) {
+		req.Header[name] = append([]string(nil), r.requestHeaders[name]...)
+	}
+	if r.file != nil {
+		r.file.attach(req)
+		bodyHash = r.file.sha256
 	} else {
-		credentials = aws.Credentials{AccessKeyID: awsAccessKeyID, SecretAccessKey: awsSecretAccessKey, SessionToken: awsSessionToken}
+		req.Body = ioutil.NopCloser(strings.NewReader(r.body))
+	}
+	if !r.hashBodyMethods[r.method] {
+		bodyHash = emptyPayloadHash
+	}
+	if r.bodyContentType != "" && (req.Header.Get("Content-Type") == "" || len(opts.formParts) > 0) {
+		req.Header.Set("Content-Type", r.bodyContentType)
+	}
+	if opts.contentMD5 && r.file != nil && r.file.size > 0 {
+		req.Header.Set("Content-MD5", r.file.md5)
+	} else if opts.contentMD5 && r.body != "" {
+		setContentMD5(req, r.body)
+	}
+	if r.cache != nil {
+		r.cache.addValidators(req)
+	}
+	r.sign(req, bodyHash)
+	return req, bodyHash, nil
+}
+
+// setupClient creates the signer and the client the requests are sent with,
+// then assumes the role and verifies the credentials when asked to.
+func (r *runner) setupClient() error {
+	opts := &r.opts
+	var err error
+	var signerOptions []func(*v4.SignerOptions)
+	if r.bundle != nil {
+		signerOptions = append(signerOptions, r.bundle.captureSigning)
+	}
+	r.signer = newSigner(opts.service, signerOptions...)
+
+	r.transport, err = newTransport(transportOptions{
+		ipVersion:           opts.ipVersion,
+		maxIdleConnsPerHost: opts.concurrency,
+		disableCompression:  opts.disableCompression,
+		unixSocket:          opts.unixSocket,
+		connectTimeout:      r.connectTimeout,
+		disableKeepAlives:   opts.disableKeepAlive,
+		dnsServer:           r.dnsServer,
+	})
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(r.body))
+	payloadHash := hex.EncodeToString(sum[:])
+	if r.file != nil {
+		payloadHash = r.file.sha256
+	}
+	if !r.hashBodyMethods[r.method] {
+		payloadHash = emptyPayloadHash
+	}
+	r.redirects = &redirectPolicy{max: opts.maxRedirects, payloadHash: payloadHash, sign: r.sign}
+	r.client = &http.Client{Timeout: r.requestTimeout, Transport: r.transport, CheckRedirect: r.redirects.checkRedirect}
+
+	stsClient := newSTSClient(r.requestTimeout)
+	if opts.assumeRoleARN != "" {
+		assumed, arn, err := assumeRole(context.Background(), stsClient, r.credentials, stsEndpoint(r.awsRegion), r.awsRegion, r.roleInput)
+		if err != nil {
+			return fmt.Errorf("error trying to assume the role %s, sts:AssumeRole failed %s", opts.assumeRoleARN, err)
+		}
+		logger.addSecret(assumed.SecretAccessKey)
+		logger.addSecret(assumed.SessionToken)
+		fields := logFields{"arn": arn}
+		if parts := strings.Split(arn, ":"); len(parts) > 4 {
+			fields["arn"] = maskAccount(arn, parts[4])
+		}
+		if assumed.CanExpire {
+			fields["expires"] = assumed.Expires.UTC().Format(time.RFC3339)
+		}
+		logger.Info("role assumed", fields)
+		r.credentials = assumed
+	}
+
+	if opts.verifyCredentials {
+		identity, err := getCallerIdentity(context.Background(), stsClient, r.credentials, stsEndpoint(r.awsRegion), r.awsRegion)
+		if err != nil {
+			return fmt.Errorf("your credentials are invalid or expired, sts:GetCallerIdentity failed %s", err)
+		}
+		logger.Info("credentials verified", logFields{"account": maskAccount(identity.Account, identity.Account), "arn": maskAccount(identity.Arn, identity.Account)})
+	}
+	return nil
+}
+
+// repeatRequests sends the request -repeat times, every one signed anew, and
+// reports their stats.
+func (r *runner) repeatRequests() int {
+	opts := &r.opts
+	start := time.Now()
+	var cacheHits int32
+	stats := repeatRequest(opts.repeat, opts.concurrency, func() (int, error) {
+		// Every request gets its own signature, X-Amz-Date is only valid for a short period
+		req, _, err := r.signedRequest()
+		if err != nil {
+			return 0, err
+		}
+		if r.cache != nil {
+			if status, ok := r.cache.fresh(req.URL.String()); ok {
+				atomic.AddInt32(&cacheHits, 1)
+				return status, nil
+			}
+		}
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		// Drain the body so the connection goes back to the pool
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		if r.cache != nil {
+			return r.cache.update(req, resp), nil
+		}
+		return resp.StatusCode, nil
+	})
+	stats.CacheHits = int(cacheHits)
+
+	statsJSON, _ := json.Marshal(stats)
+	fmt.Fprintf(r.stdout, "requests: %d, success: %d, errors: %d, p50: %.1fms, p95: %.1fms\n", stats.Requests, stats.Success, stats.Errors, stats.P50, stats.P95)
+
+	result := notification{Method: r.method, URL: logger.scrub(opts.lambdaURL), DurationMS: time.Since(start).Milliseconds()}
+	code := 0
+	if stats.Errors == stats.Requests {
+		logger.Error("every request failed", nil)
+		result.Error = errorRequest
+		code = 1
+	}
+	return r.finish([]actionOutput{{"stats", string(statsJSON)}}, result, &stats, code)
+}
+
+// profileRequests sends the request once per profile of -profiles, each one
+// signed by its credentials.
+func (r *runner) profileRequests() int {
+	opts, env, stdout := &r.opts, r.env, r.stdout
+	results := make(map[string]profileResult, len(r.profiles))
+	failed := 0
+	for _, profile := range r.profiles {
+		// The same request, signed by the credentials of the profile
+		r.credentials = profile.credentials
+		req, _, err := r.signedRequest()
+		start := time.Now()
+		var result profileResult
+		var resp *http.Response
+		if err == nil {
+			resp, err = r.client.Do(req)
+		}
+		if err != nil {
+			var msg string
+			result.Error, msg = classifyRequestError(err)
+			logger.Error(fmt.Sprintf("profile %s: %s", profile.name, msg), logFields{"error": result.Error})
+			failed++
+		} else {
+			// Drain the body so the connection goes back to the pool
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			result.Status, result.Code = resp.Status, resp.StatusCode
+			if opts.failOnError && resp.StatusCode >= 400 {
+				failed++
+			}
+		}
+		result.DurationMS = time.Since(start).Milliseconds()
+		results[profile.name] = result
+		logger.Info("profile request done", logFields{"profile": profile.name, "status": result.Status, "error": result.Error, "duration_ms": result.DurationMS})
 	}
 
-	req, bodyHash := buildRequest(*lambdaURL, *requestMethod, awsRegion, *requestBody)
-	req.Body = ioutil.NopCloser(strings.NewReader(*requestBody))
+	resultsJSON, _ := json.Marshal(results)
+	fmt.Fprintf(stdout, "profiles: %d, failed: %d\n", len(r.profiles), failed)
+	writeOutputs(env[EnvGitHubOutput], stdout, []actionOutput{{"profiles", string(resultsJSON)}, {"region", r.awsRegion}, {"service", opts.service}})
+	if failed > 0 {
+		logger.Error(fmt.Sprintf("the request failed for %d of %d profiles", failed, len(r.profiles)), nil)
+		return 1
+	}
+	return 0
+}
 
-	signer := v4.NewSigner()
-	signer.SignHTTP(context.Background(), credentials, req, bodyHash, "lambda", awsRegion, time.Now())
+// singleRequest signs and sends the request, retried as configured, then
+// writes the outputs of its response.
+func (r *runner) singleRequest() int {
+	opts, env, stdout := &r.opts, r.env, r.stdout
+	// What the flags, env variables and URL resolved to, unsigned requests have none
+	var signingOutputs []actionOutput
+	if !opts.anonymous {
+		signingOutputs = []actionOutput{{"region", r.awsRegion}, {"service", opts.service}}
+	}
 
-	client := &http.Client{Timeout: time.Duration(5) * time.Second}
-	resp, err := client.Do(req)
+	req, bodyHash, err := r.signedRequest()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "HTTP error %s\n", err)
-		os.Exit(1)
+		logger.Error(err.Error(), nil)
+		return 1
+	}
+	if opts.expectedSignature != "" {
+		if err := checkSignature(req.Header.Get("Authorization"), opts.expectedSignature); err != nil {
+			logger.Error(err.Error(), nil)
+			return 1
+		}
+		logger.Info("signature matches the expected one", nil)
+	}
+	if opts.emitSignedHeaders != "" {
+		// Another client sends the request, it must send this exact body
+		headers, err := formatSignedHeaders(req, opts.emitSignedHeaders)
+		if err != nil {
+			logger.Error(err.Error(), nil)
+			return 1
+		}
+		auth := parseAuthorization(req.Header.Get("Authorization"))
+		logger.Info("request signed, not sent", logFields{"method": req.Method, "url": req.URL.String(), "headers": headerFields(req.Header)})
+		writeOutputs(env[EnvGitHubOutput], stdout, append([]actionOutput{{"request_headers", headers}, {"signed_headers", auth.SignedHeaders}, {"credential_scope", auth.scope()}, {"body_sha256", bodyHash}}, signingOutputs...))
+		return 0
+	}
+	var attempts *attemptLog
+	if opts.attemptLog != "" {
+		if attempts, err = openAttemptLog(opts.attemptLog); err != nil {
+			logger.Error(fmt.Sprintf("error opening the attempt log %s", err), nil)
+			return 1
+		}
+		defer attempts.Close()
+	}
+	ctx := context.Background()
+	if r.totalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.totalTimeout)
+		defer cancel()
+	}
+	logger.Debug("sending signed request", logFields{"method": req.Method, "url": req.URL.String(), "region": r.awsRegion, "headers": headerFields(req.Header)})
+
+	result := notification{Method: req.Method, URL: logger.scrub(req.URL.String())}
+	sent := r.send(ctx, req, bodyHash, attempts)
+	outputs, code := r.respond(sent, &result, signingOutputs)
+	result.DurationMS = time.Since(sent.start).Milliseconds()
+	return r.finish(outputs, result, nil, code)
+}
+
+// sentRequest is what the send phase hands over to the output phase: the
+// request last sent, with its response or the error it got instead.
+type sentRequest struct {
+	req      *http.Request
+	bodyHash string
+	start    time.Time
+	trace    *requestTrace
+	resp     *http.Response
+	retried  retryResult
+	err      error
+}
+
+// send sends the request, every retry signed anew, and records the attempts
+// in the attempt log if any.
+func (r *runner) send(ctx context.Context, req *http.Request, bodyHash string, attempts *attemptLog) *sentRequest {
+	opts := &r.opts
+	s := &sentRequest{req: req, bodyHash: bodyHash, start: time.Now()}
+	retries := retryPolicy{retries: opts.retries, backoff: r.retryBackoff, statuses: r.retryStatuses}
+	sent := false
+	attempt := 0
+	s.resp, s.retried, s.err = retries.do(ctx, func(ctx context.Context) (*http.Response, error) {
+		if sent {
+			// Every retry gets its own signature
+			var err error
+			if s.req, s.bodyHash, err = r.signedRequest(); err != nil {
+				return nil, err
+			}
+		}
+		sent = true
+		s.req = s.req.WithContext(ctx)
+		if opts.trace || opts.harFile != "" || r.bundle != nil {
+			s.req, s.trace = withTrace(s.req)
+		}
+		if attempts == nil {
+			return r.client.Do(s.req)
+		}
+		attempt++
+		record := attemptRecord{Time: time.Now().UTC(), Attempt: attempt, Method: s.req.Method, URL: logger.scrub(s.req.URL.String())}
+		resp, err := r.client.Do(s.req)
+		record.DurationMS = float64(time.Since(record.Time).Microseconds()) / 1000
+		if err != nil {
+			record.Error, _ = classifyRequestError(err)
+		} else {
+			record.Status = resp.StatusCode
+		}
+		if err := attempts.record(record); err != nil {
+			logger.Warn(fmt.Sprintf("error writing the attempt log %s", err), nil)
+		}
+		return resp, err
+	})
+	return s
+}
+
+// respond is the output phase: the outputs of the response, or of the error
+// it got instead, and the exit code they lead to.
+func (r *runner) respond(s *sentRequest, result *notification, signingOutputs []actionOutput) ([]actionOutput, int) {
+	opts, stdout := &r.opts, r.stdout
+	req, resp, start, retried := s.req, s.resp, s.start, s.retried
+	writeMetricsFile := func(code int) {
+		if opts.metricsFile == "" {
+			return
+		}
+		metrics := requestMetrics{method: req.Method, host: req.URL.Host, duration: time.Since(start), code: code, retries: retried.attempts - 1}
+		if err := writeMetrics(opts.metricsFile, metrics); err != nil {
+			logger.Warn(fmt.Sprintf("error writing the metrics file %s", err), nil)
+		}
+	}
+	var retryOutputs []actionOutput
+	if retried.attempts > 1 {
+		retryOutputs = append(retryOutputs, actionOutput{"retry_count", strconv.Itoa(retried.attempts - 1)}, actionOutput{"last_error", retried.lastError})
+	}
+	if opts.retries > 0 && retried.stop != "" {
+		retryOutputs = append(retryOutputs, actionOutput{"retry_stop", retried.stop})
+		logger.Warn(fmt.Sprintf("giving up after %d attempts", retried.attempts), logFields{"retry_stop": retried.stop})
+	}
+	if s.err != nil {
+		kind, msg := classifyRequestError(s.err)
+		result.Error = kind
+		logger.Error(msg, logFields{"error": kind})
+		writeMetricsFile(0)
+		return append(append([]actionOutput{{"error", kind}}, signingOutputs...), retryOutputs...), 1
 	}
 	defer resp.Body.Close()
-	respBody, err := ioutil.ReadAll(resp.Body)
+	logger.Debug("received response", logFields{"status": resp.Status, "duration_ms": time.Since(start).Milliseconds(), "headers": headerFields(resp.Header)})
+	writeMetricsFile(resp.StatusCode)
+	result.Status, result.Code, result.RequestID = resp.Status, resp.StatusCode, requestID(resp.Header)
+
+	// Written before any non-zero exit so a failed step still exposes what the
+	// endpoint answered
+	outputs := []actionOutput{
+		{"status", resp.Status},
+		{"code", strconv.Itoa(resp.StatusCode)},
+		{"body_sha256", s.bodyHash},
+		{"content_type", resp.Header.Get("Content-Type")},
+		{"bytes_sent", strconv.FormatInt(r.transport.bytesSent(), 10)},
+	}
+	// Every value of every header, a multi-value header such as Set-Cookie included
+	headersJSON, _ := json.Marshal(resp.Header)
+	outputs = append(outputs, actionOutput{"headers", string(headersJSON)})
+	if auth := parseAuthorization(req.Header.Get("Authorization")); auth.SignedHeaders != "" {
+		outputs = append(outputs, actionOutput{"signed_headers", auth.SignedHeaders}, actionOutput{"credential_scope", auth.scope()})
+	}
+	outputs = append(outputs, signingOutputs...)
+	outputs = append(outputs, retryOutputs...)
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		outputs = append(outputs, actionOutput{"error", errorPreconditionFailed})
+		result.Error = errorPreconditionFailed
+		logger.Warn("the precondition failed, the resource changed since it was read", logFields{"if_match": req.Header.Get("If-Match"), "if_unmodified_since": req.Header.Get("If-Unmodified-Since")})
+	}
+	if r.invocationType != "" {
+		checkInvocationStatus(r.invocationType, resp.StatusCode)
+	}
+	fail := func(msg string) ([]actionOutput, int) {
+		result.Error = msg
+		logger.Error(msg, nil)
+		return outputs, 1
+	}
+
+	// Counted before decoding, the size the body had on the wire
+	received := &countingReader{r: resp.Body}
+	bodyReader, err := decodeContentEncoding(resp.Header.Get("Content-Encoding"), received)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error trying to decode response body %s\n", err)
+		return fail(fmt.Sprintf("error trying to decode response body %s", err))
+	}
+
+	// The body is read once and teed to every sink configured: -output-file,
+	// -output-fd or else the message output
+	var sinks []*bodySink
+	if opts.outputFile != "" {
+		f, err := os.OpenFile(opts.outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return fail(fmt.Sprintf("error trying to write response body to %s %s", opts.outputFile, err))
+		}
+		defer f.Close()
+		sinks = append(sinks, &bodySink{name: opts.outputFile, w: f})
+	}
+
+	var respBody []byte
+	if opts.outputFD != "" {
+		// Stream the body to the consumer without buffering it in memory
+		out, err := openOutputFD(opts.outputFD)
+		if err != nil {
+			return fail(err.Error())
+		}
+		defer out.Close()
+		failed, err := copyToSinks(bodyReader, append(sinks, &bodySink{name: opts.outputFD, w: out}))
+		if failed != nil {
+			return fail(fmt.Sprintf("error trying to write response body to %s %s", failed.name, failed.err))
+		}
+		if err != nil {
+			return fail(fmt.Sprintf("error trying to read response body %s", err))
+		}
+		fmt.Fprintf(stdout, "status code: %s, response written to %s\n", resp.Status, opts.outputFD)
+	} else {
+		var raw bytes.Buffer
+		failed, err := copyToSinks(bodyReader, append(sinks, &bodySink{name: "message", w: &raw}))
+		if err != nil {
+			logger.Error(fmt.Sprintf("error trying to decode response body %s", err), nil)
+		}
+		if failed != nil {
+			return fail(fmt.Sprintf("error trying to write response body to %s %s", failed.name, failed.err))
+		}
+		message := ""
+		if opts.responseBase64 {
+			// Raw bytes, a charset conversion would corrupt binary content
+			respBody = raw.Bytes()
+			message = base64.StdEncoding.EncodeToString(respBody)
+			outputs = append(outputs, actionOutput{"body_encoding", "base64"})
+		} else {
+			respBody, _ = readMessage(resp.Header.Get("Content-Type"), &raw)
+			message = string(respBody)
+		}
+		transformed := false
+		if r.jqTransform != nil {
+			if result, err := r.jqTransform.transform(respBody); err != nil {
+				logger.Warn(fmt.Sprintf("-jq not applied, %s, the message is the body as is", err), nil)
+			} else {
+				message, transformed = result, true
+			}
+		}
+		printed := message
+		// Outputs are limited in size, a huge body would break the step
+		if truncated, ok := truncateOutput(message, opts.maxOutputBytes); ok {
+			logger.Warn(fmt.Sprintf("the response body is %d bytes, the message output is truncated to %d bytes", len(message), opts.maxOutputBytes), logFields{"output_file": opts.outputFile})
+			message = truncated
+			outputs = append(outputs, actionOutput{"truncated", "true"})
+		}
+		outputs = append(outputs, actionOutput{"message", message})
+		if !opts.responseBase64 && !transformed && (opts.pretty || isTerminal(stdout)) {
+			printed = string(prettyJSON(resp.Header.Get("Content-Type"), respBody))
+		}
+		fmt.Fprintf(stdout, "status code: %s, response: %s", resp.Status, printed)
+		if opts.verbose && resp.StatusCode == http.StatusForbidden && isClockSkewError(respBody) {
+			logger.Warn("the request was refused because of clock skew, check the runner clock or use -clock-skew to offset the signing time", logFields{"clock_skew": r.skew.String()})
+		}
 	}
 
-	fmt.Printf("status code: %s, response: %s", resp.Status, string(respBody))
+	outputs = append(outputs, actionOutput{"bytes_received", strconv.FormatInt(received.n, 10)})
 
+	if hops := r.redirects.chain(); len(hops) > 0 {
+		redirectsJSON, _ := json.Marshal(hops)
+		outputs = append(outputs, actionOutput{"redirects", string(redirectsJSON)})
+		logger.Debug("followed redirects", logFields{"redirects": len(hops), "url": resp.Request.URL.String()})
+	}
+
+	var timings traceTimings
+	if s.trace != nil {
+		timings = s.trace.timings(time.Now())
+	}
+	if opts.trace {
+		traceJSON, _ := json.Marshal(timings)
+		outputs = append(outputs, actionOutput{"trace", string(traceJSON)})
+		logger.Info("request timings", logFields{"dns_ms": timings.DNS, "connect_ms": timings.Connect, "tls_handshake_ms": timings.TLSHandshake, "ttfb_ms": timings.TimeToFirstByte, "total_ms": timings.Total})
+	}
+
+	duration := time.Since(start)
+
+	if opts.tlsInfo {
+		if info := peerCertificateInfo(resp.TLS); info != nil {
+			tlsJSON, _ := json.Marshal(info)
+			outputs = append(outputs, actionOutput{"tls", string(tlsJSON)})
+			logger.Info("served certificate", logFields{"subject": info.Subject, "issuer": info.Issuer, "not_after": info.NotAfter})
+		} else {
+			logger.Warn("no TLS certificate, the endpoint was not called over HTTPS", nil)
+		}
+	}
+
+	exchange := harExchange{start: start, req: req, resp: resp, responseBody: respBody, timings: timings}
+	if r.file == nil {
+		exchange.requestBody = []byte(r.body)
+	}
+	if r.bundle != nil {
+		r.bundle.setExchange(exchange)
+	}
+	if opts.harFile != "" {
+		if err := writeHAR(opts.harFile, newHAR(exchange)); err != nil {
+			logger.Warn(fmt.Sprintf("error writing the HAR file %s", err), nil)
+		}
+	}
+	if opts.openAPIExampleFile != "" {
+		if err := writeOpenAPIExample(opts.openAPIExampleFile, newOpenAPIExample(exchange)); err != nil {
+			logger.Warn(fmt.Sprintf("error writing the OpenAPI example file %s", err), nil)
+		}
+	}
+
+	// Checks failing the action once the outputs are written, whatever the status
+	failedCheck := ""
+	if r.maxLatency > 0 && duration > r.maxLatency {
+		logger.Error(fmt.Sprintf("the request took %dms, more than the %dms allowed by -max-latency", duration.Milliseconds(), r.maxLatency.Milliseconds()), nil)
+		failedCheck = errorLatencyExceeded
+	}
+	if opts.expectContentType != "" {
+		if err := checkContentType(resp.Header.Get("Content-Type"), opts.expectContentType); err != nil {
+			logger.Error(err.Error(), nil)
+			failedCheck = errorContentType
+		}
+	}
+	if r.responseSchema != nil {
+		if contentType := resp.Header.Get("Content-Type"); !isJSONContentType(contentType) {
+			logger.Warn(fmt.Sprintf("the response is not validated against -response-schema, its Content-Type %q is not JSON", contentType), nil)
+		} else if violations := r.responseSchema.validate(respBody); len(violations) > 0 {
+			for _, violation := range violations {
+				logger.Error(fmt.Sprintf("response does not match the schema, %s", violation), nil)
+			}
+			violationsJSON, _ := json.Marshal(violations)
+			outputs = append(outputs, actionOutput{"schema_errors", string(violationsJSON)})
+			failedCheck = errorSchemaMismatch
+		}
+	}
+	if r.golden != nil {
+		if diff := r.golden.compare(respBody); diff != "" {
+			logger.Error(fmt.Sprintf("the response differs from the golden file %s\n%s", opts.goldenFile, diff), nil)
+			if truncated, ok := truncateOutput(diff, opts.maxOutputBytes); ok {
+				diff = truncated
+			}
+			outputs = append(outputs, actionOutput{"golden_diff", diff})
+			failedCheck = errorGoldenMismatch
+		}
+	}
+	// Success is signaled by a header rather than the status for some contracts
+	for _, expectation := range r.headerExpectations {
+		if err := expectation.check(resp.Header); err != nil {
+			logger.Error(err.Error(), nil)
+			failedCheck = errorHeaderMismatch
+		}
+	}
+	if failedCheck != "" {
+		outputs = append(outputs, actionOutput{"error", failedCheck})
+		result.Error = failedCheck
+	}
+	bodyMatched := false
+	if r.successPattern != nil {
+		bodyMatched = r.successPattern.Match(respBody)
+		outputs = append(outputs, actionOutput{"body_matched", strconv.FormatBool(bodyMatched)})
+	}
+
+	if failedCheck != "" {
+		return outputs, 1
+	}
+	if opts.failOnError && resp.StatusCode >= 400 {
+		if bodyMatched {
+			logger.Info(fmt.Sprintf("the response body matches -success-on-body-regex, status %s treated as a success", resp.Status), nil)
+			return outputs, 0
+		}
+		logger.Error(fmt.Sprintf("request failed with status %s", resp.Status), nil)
+		return outputs, 1
+	}
+	return outputs, 0
+}
+
+// finish is the epilogue of a run that sent its requests, whatever came out
+// of them: the outputs, then the job summary and the notification of result.
+// stats are those of a -repeat run, summarized but not notified.
+func (r *runner) finish(outputs []actionOutput, result notification, stats *repeatStats, code int) int {
 	// Github Action outputs
-	fmt.Printf(`::set-output name=status::%s`, resp.Status)
-	fmt.Print("\n")
-	fmt.Printf(`::set-output name=code::%d`, resp.StatusCode)
-	fmt.Print("\n")
-	fmt.Printf(`::set-output name=message::%s`, string(respBody))
-	fmt.Print("\n")
+	writeOutputs(r.env[EnvGitHubOutput], r.stdout, outputs)
+	if r.opts.summary {
+		summary := stepSummary{Method: result.Method, URL: result.URL, Status: result.Status, Code: result.Code, Duration: time.Duration(result.DurationMS) * time.Millisecond, RequestID: result.RequestID, Error: result.Error, Stats: stats}
+		if err := writeStepSummary(r.env[EnvGitHubStepSummary], summary); err != nil {
+			logger.Warn(err.Error(), nil)
+		}
+	}
+	if r.opts.notifyURL != "" && stats == nil {
+		// The failures are the most worth knowing about
+		if err := sendNotification(&http.Client{Timeout: notifyTimeout}, r.opts.notifyURL, r.opts.notifyFormat, result); err != nil {
+			logger.Warn(fmt.Sprintf("error sending the notification %s", err), nil)
+		}
+	}
+	return code
+}
+
+// envCredentials reads the static credentials of the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and optional AWS_SESSION_TOKEN env variables.
+func envCredentials(env map[string]string) (aws.Credentials, error) {
+	credentials := aws.Credentials{
+		AccessKeyID:     env[EnvAWSAccessKeyID],
+		SecretAccessKey: env[EnvAWSSecretAccessKey],
+		SessionToken:    env[EnvAWSSessionToken],
+	}
+	if credentials.AccessKeyID == "" {
+		return credentials, fmt.Errorf("%s env variable is required", EnvAWSAccessKeyID)
+	}
+	if credentials.SecretAccessKey == "" {
+		return credentials, fmt.Errorf("%s env variable is required", EnvAWSSecretAccessKey)
+	}
+	return credentials, nil
+}
+
+// accessKeyIDRegExp matches the long-term (AKIA) and temporary (ASIA) access
+// key IDs, a 4 letters prefix followed by 16 base32 characters.
+var accessKeyIDRegExp = regexp.MustCompile(`^A[KS]IA[A-Z2-7]{16}$`)
+
+// otherKeyIDRegExp matches the access key IDs of any other form IAM documents:
+// 16 to 128 alphanumeric characters.
+var otherKeyIDRegExp = regexp.MustCompile(`^[A-Za-z0-9]{16,128}$`)
+
+// checkAccessKeyID reports an access key ID that does not look like one, such
+// as a secret access key set in its place or a value with stray whitespace.
+func checkAccessKeyID(id string) error {
+	switch {
+	case strings.TrimSpace(id) != id:
+		return fmt.Errorf("%s has leading or trailing whitespace", EnvAWSAccessKeyID)
+	case strings.HasPrefix(id, "AKIA") || strings.HasPrefix(id, "ASIA"):
+		if !accessKeyIDRegExp.MatchString(id) {
+			return fmt.Errorf("%s does not look like an access key ID, expected %s followed by 16 uppercase letters or digits", EnvAWSAccessKeyID, id[:4])
+		}
+	case !otherKeyIDRegExp.MatchString(id):
+		return fmt.Errorf("%s does not look like an access key ID, expected 16 to 128 letters or digits such as AKIA...", EnvAWSAccessKeyID)
+	}
+	return nil
 }
 
-func buildRequest(lambdaURL, requestMethod, region, requestBody string) (*http.Request, string) {
+// readSessionToken reads the session token of -session-token-file, long
+// tokens are easier to write to a file than to export.
+func readSessionToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error trying to read -session-token-file %s", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("-session-token-file %s is empty", path)
+	}
+	return token, nil
+}
+
+// resolveMethod returns the HTTP method of the request. Same as curl, sending
+// form data implies a POST unless a method is given.
+func resolveMethod(method string, hasFormData bool) string {
+	if method != "" {
+		return method
+	}
+	if hasFormData {
+		return http.MethodPost
+	}
+	return http.MethodGet
+}
+
+func buildRequest(lambdaURL, requestMethod, region, requestBody string) (*http.Request, string, error) {
 	reader := strings.NewReader(requestBody)
 	return buildRequestWithBodyReader(lambdaURL, requestMethod, region, reader)
 }
 
-func buildRequestWithBodyReader(lambdaURL, requestMethod, region string, requestBody io.Reader) (*http.Request, string) {
+func buildRequestWithBodyReader(lambdaURL, requestMethod, region string, requestBody io.Reader) (*http.Request, string, error) {
 
 	req, err := http.NewRequest(requestMethod, lambdaURL, requestBody)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error building the http request %s\n", err)
-		os.Exit(1)
+		return nil, "", fmt.Errorf("error building the http request %s", err)
 	}
-
-	req = addHeaders(*headerList, req)
+	preserveEscapedPath(req.URL)
 
 	h := sha256.New()
 	_, _ = io.Copy(h, requestBody)
 	payloadHash := hex.EncodeToString(h.Sum(nil))
 
-	return req, payloadHash
+	return req, payloadHash, nil
+}
+
+// preserveEscapedPath keeps the path of u encoded the way it was given, so
+// an escaped segment such as a%2Fb is sent and signed as is. url.URL only
+// keeps the original encoding when it is entirely valid, a single character
+// to escape (e.g. a space) otherwise makes it re-encode the decoded path and
+// a%2Fb becomes a/b. Those characters are escaped in place instead.
+func preserveEscapedPath(u *url.URL) {
+	if u.RawPath == "" || u.EscapedPath() == u.RawPath {
+		return
+	}
+	var b strings.Builder
+	for i := 0; i < len(u.RawPath); i++ {
+		c := u.RawPath[i]
+		if c == '%' && i+2 < len(u.RawPath) && isHex(u.RawPath[i+1]) && isHex(u.RawPath[i+2]) {
+			b.WriteString(u.RawPath[i : i+3])
+			i += 2
+		} else if isPathChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	u.RawPath = b.String()
+}
+
+// isPathChar reports whether c can appear unescaped in a path: unreserved
+// characters, sub-delimiters, ':', '@' and the '/' separator (RFC 3986).
+func isPathChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-._~!$&'()*+,;=:@/", c) >= 0
+}
+
+// appendQuery adds the name=value parameters of -query to the query of
+// rawURL, which is rewritten in its SigV4 canonical form: sorted by name, RFC
+// 3986 encoded, the values of a name kept in the order given. The signer sends
+// the query that way whatever it was, doing it beforehand keeps the logged URL
+// the one sent. The rest of the URL is left untouched, see
+// preserveEscapedPath, the fragment after the query.
+func appendQuery(rawURL string, params []string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid lambda-url %s", err)
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return "", fmt.Errorf("invalid query of lambda-url %s", err)
+	}
+	for _, param := range params {
+		i := strings.Index(param, "=")
+		if i <= 0 {
+			return "", fmt.Errorf("invalid -query %q, expected name=value", param)
+		}
+		query.Add(param[:i], param[i+1:])
+	}
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	// Everything before the query or fragment as given, re-encoding it would undo preserveEscapedPath
+	base, fragment := rawURL, ""
+	if i := strings.Index(base, "#"); i >= 0 {
+		base, fragment = base[:i], base[i:]
+	}
+	if i := strings.Index(base, "?"); i >= 0 {
+		base = base[:i]
+	}
+	return base + "?" + u.RawQuery + fragment, nil
+}
+
+// restoreQueryOrder undoes the sort of the values of a name the signer applies
+// to the query it sends, keeping its encoding. The canonical request sorts
+// them anyway so the signature still matches, and the endpoint gets repeated
+// parameters in the order given.
+func restoreQueryOrder(req *http.Request, rawQuery string) {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return
+	}
+	req.URL.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// addHeaders adds the "Name: value" lines of headerList to the request, they
+// replace the values a header already set on the request (a default) has. When
+// lookupEnv is set, ${VAR} references in the values are replaced beforehand so
+// the final values are the ones covered by the signature.
+func addHeaders(headerList string, req *http.Request, lookupEnv func(string) (string, bool)) *http.Request {
+	req.Header = mergeHeaders(parseHeaders(headerList, lookupEnv), req.Header)
+	return req
+}
+
+// mergeHeaders adds to headers the defaults it has no value for. Names are
+// compared in their canonical form, so case variants are the same header and
+// the result does not depend on the order of either list.
+func mergeHeaders(headers, defaults http.Header) http.Header {
+	for name, values := range defaults {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := headers[name]; !ok {
+			headers[name] = append([]string(nil), values...)
+		}
+	}
+	return headers
 }
 
-func addHeaders(headerList string, req *http.Request) *http.Request {
+// readDefaultHeaders parses the "Name: value" lines of -default-headers-file
+// like -headers, blank lines and # comments left out.
+func readDefaultHeaders(path string, lookupEnv func(string) (string, bool)) (http.Header, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error trying to read -default-headers-file %s", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return http.Header{}, nil
+	}
+	return parseHeaders(strings.Join(lines, "\n"), lookupEnv), nil
+}
+
+// parseHeaders parses the "Name: value" lines of headerList, see addHeaders.
+// Interpolated values may carry secrets (tokens, API keys) and are therefore
+// never logged.
+func parseHeaders(headerList string, lookupEnv func(string) (string, bool)) http.Header {
+	parsed := http.Header{}
 	headers := strings.Split(strings.TrimSpace(headerList), "\n")
 	for _, header := range headers {
-		headerArr := strings.Split(header, ":")
+		headerArr := strings.SplitN(header, ":", 2)
 		if len(headerArr) < 2 {
-			fmt.Fprintf(os.Stdout, "ignore invalid header %s\n", header)
+			logger.Warn(fmt.Sprintf("ignore invalid header %s", header), nil)
 		} else {
-			req.Header.Add(strings.TrimSpace(headerArr[0]), strings.TrimSpace(headerArr[1]))
+			name, value := strings.TrimSpace(headerArr[0]), strings.TrimSpace(headerArr[1])
+			if lookupEnv != nil {
+				if interpolated := interpolateEnv(name, value, lookupEnv); interpolated != value {
+					logger.addSecret(interpolated)
+					value = interpolated
+				}
+			}
+			parsed.Add(name, value)
 		}
 	}
-	return req
+	return parsed
+}
+
+// traceHeaders maps the env variables carrying a tracing context to the
+// headers propagating it: W3C Trace Context and AWS X-Ray, as set in Lambda.
+var traceHeaders = []struct{ env, header string }{
+	{"TRACEPARENT", "Traceparent"},
+	{"TRACESTATE", "Tracestate"},
+	{"_X_AMZN_TRACE_ID", "X-Amzn-Trace-Id"},
+}
+
+// propagateTraceHeaders adds the tracing context found in env to header,
+// headers given explicitly win. They are set before signing and therefore
+// signed, except X-Amzn-Trace-Id which SigV4 leaves out on purpose since
+// load balancers and API Gateway append to it on the way.
+func propagateTraceHeaders(header http.Header, env map[string]string) {
+	for _, h := range traceHeaders {
+		if value := strings.TrimSpace(env[h.env]); value != "" && header.Get(h.header) == "" {
+			header.Set(h.header, value)
+			logger.Debug(fmt.Sprintf("propagating %s as header %s", h.env, h.header), nil)
+		}
+	}
+}
+
+// headerNames returns the names of header in sorted order.
+func headerNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// defaultMaxHeaderBytes is below the header limits of API Gateway (10 KB per
+// header) and CloudFront (20 KB overall) once the signature is added.
+const defaultMaxHeaderBytes = 8 * 1024
+
+// checkHeaderSize rejects headers larger than max bytes, on their own or all
+// together as sent ("Name: value\r\n" lines), 0 disables the check. Values
+// are never part of the error, they can be secrets.
+func checkHeaderSize(header http.Header, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	names := headerNames(header)
+
+	total := 0
+	for _, name := range names {
+		for _, value := range header[name] {
+			size := len(name) + len(": ") + len(value) + len("\r\n")
+			if size > max {
+				return fmt.Errorf("header %s is %d bytes, more than the %d bytes allowed by -max-header-bytes", name, size, max)
+			}
+			total += size
+		}
+	}
+	if total > max {
+		return fmt.Errorf("headers are %d bytes in total, more than the %d bytes allowed by -max-header-bytes", total, max)
+	}
+	return nil
+}
+
+// Values of -duplicate-headers, how headers given more than once (whatever
+// their case, Content-Type and content-type are the same header) are sent.
+const (
+	duplicateHeadersMerge = "merge"
+	duplicateHeadersLast  = "last"
+)
+
+// dedupeHeaders collapses the values of every header given more than once
+// into a single one: joined by commas when merging, the way a server reads a
+// repeated header, or the last one given.
+func dedupeHeaders(header http.Header, mode string) (http.Header, error) {
+	if mode != duplicateHeadersMerge && mode != duplicateHeadersLast {
+		return nil, fmt.Errorf("invalid duplicate headers mode %q, expected %s or %s", mode, duplicateHeadersMerge, duplicateHeadersLast)
+	}
+	deduped := make(http.Header, len(header))
+	for name, values := range header {
+		if len(values) > 1 {
+			logger.Debug(fmt.Sprintf("header %s given %d times", name, len(values)), logFields{"mode": mode})
+		}
+		if mode == duplicateHeadersLast {
+			deduped[name] = []string{values[len(values)-1]}
+		} else {
+			deduped[name] = []string{strings.Join(values, ", ")}
+		}
+	}
+	return deduped, nil
+}
+
+var envReferenceRegExp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolateEnv replaces the ${VAR} references of a header value, undefined
+// variables are replaced by an empty string and reported.
+func interpolateEnv(header, value string, lookupEnv func(string) (string, bool)) string {
+	return envReferenceRegExp.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReferenceRegExp.FindStringSubmatch(ref)[1]
+		v, ok := lookupEnv(name)
+		if !ok {
+			logger.Warn(fmt.Sprintf("env variable %s referenced by header %s is not defined", name, header), nil)
+		}
+		return v
+	})
+}
+
+// resolveRegion picks the signing region from the -region flag, then the
+// AWS_REGION env variable and finally, unless disabled, from the URL itself.
+// Regions are lowercased and trimmed first, SigV4 signatures only match the
+// lowercase form (eu-west-1, not EU-WEST-1).
+func resolveRegion(regionFlag, envRegion, lambdaURL string, guess bool) (string, error) {
+	regionFlag, envRegion = normalizeRegion(regionFlag), normalizeRegion(envRegion)
+	if regionFlag != "" {
+		return regionFlag, nil
+	}
+	if envRegion != "" {
+		return envRegion, nil
+	}
+	if !guess {
+		return "", fmt.Errorf("AWS region is not specified, -region or %s env variable is required when region guessing is disabled", EnvAWSRegion)
+	}
+
+	logger.Info("AWS region is not specified, try to guess from lambda URL", nil)
+	// Try to extract region from function URL => https://<id>.lambda-url.<region>.on.aws/
+	return guessAWSRegion(lambdaURL)
+}
+
+// normalizeRegion returns region trimmed and lowercased.
+func normalizeRegion(region string) string {
+	return strings.ToLower(strings.TrimSpace(region))
 }
 
 func guessAWSRegion(lambdaURL string) (string, error) {
 	u, _ := url.Parse(lambdaURL)
+	if host, ok := parseS3Host(u.Hostname()); ok {
+		// Bucket names may hold anything looking like a region
+		if host.region == "" {
+			return s3GlobalRegion, nil
+		}
+		return host.region, nil
+	}
 	r := regexp.MustCompile(awsRegionRegExp)
 
-	result := r.FindStringSubmatch(u.Hostname())
+	result := r.FindStringSubmatch(strings.ToLower(u.Hostname()))
 	if result == nil {
 		return "", errors.New("lambda function URL is malformed, impossible to guess AWS region")
 	}
-	return result[0], nil
+	return result[1], nil
+}
+
+// openOutputFD opens the destination of the -output-fd flag, which is either
+// a file descriptor number inherited from the parent process or the path of
+// an existing named pipe (or file).
+func openOutputFD(spec string) (io.WriteCloser, error) {
+	if fd, err := strconv.Atoi(spec); err == nil {
+		if fd < 0 {
+			return nil, fmt.Errorf("invalid output file descriptor %d", fd)
+		}
+		return os.NewFile(uintptr(fd), "fd"+spec), nil
+	}
+
+	f, err := os.OpenFile(spec, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening output %s: %s", spec, err)
+	}
+	return f, nil
 }
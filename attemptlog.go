@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// attemptRecord is a line of the -attempt-log file, describing one attempt:
+// the status of its response or, when none was received, the error kind.
+type attemptRecord struct {
+	Time       time.Time `json:"time"`
+	Attempt    int       `json:"attempt"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// attemptLog appends JSON lines to a file, keeping the lines of the previous
+// runs so a flaky endpoint can be looked at over time.
+type attemptLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openAttemptLog(path string) (*attemptLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &attemptLog{file: f}, nil
+}
+
+// record writes one line, in a single write so concurrent writers to the same
+// file do not interleave.
+func (l *attemptLog) record(r attemptRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+func (l *attemptLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttemptLogAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attempts.jsonl")
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 2; i++ {
+		log, err := openAttemptLog(path)
+		assert.Nil(t, err, "should not be any error")
+		assert.Nil(t, log.record(attemptRecord{Time: at, Attempt: i, Method: "GET", URL: "https://example.com/", Status: 200, DurationMS: 1.5}))
+		assert.Nil(t, log.Close())
+	}
+	data, _ := ioutil.ReadFile(path)
+	line := `{"time":"2024-03-01T12:00:00Z","attempt":%d,"method":"GET","url":"https://example.com/","status":200,"duration_ms":1.5}` + "\n"
+	assert.Equal(t, fmt.Sprintf(line, 1)+fmt.Sprintf(line, 2), string(data), "a new log should not truncate the previous lines")
+}
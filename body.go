@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const formURLEncoded = "application/x-www-form-urlencoded"
+
+// appendURLEncodedData mirrors curl's --data-urlencode: every item is URL-encoded
+// and joined to the body with '&'. Items follow curl's syntax:
+//
+//	content        the content is encoded
+//	=content       the content is encoded (allows '=' and '@' in it)
+//	name=content   name is sent as is, content is encoded
+//	@file          the file content is encoded
+//	name@file      name is sent as is, the file content is encoded
+func appendURLEncodedData(body string, items []string) (string, error) {
+	parts := []string{}
+	if body != "" {
+		parts = append(parts, body)
+	}
+
+	for _, item := range items {
+		name, content := "", item
+		i := strings.Index(item, "=")
+		if i < 0 {
+			i = strings.Index(item, "@")
+		}
+		if i >= 0 {
+			name, content = item[:i], item[i+1:]
+			if item[i] == '@' {
+				data, err := ioutil.ReadFile(content)
+				if err != nil {
+					return "", err
+				}
+				content = string(data)
+			}
+		}
+
+		if name == "" {
+			parts = append(parts, escapeRFC3986(content))
+		} else {
+			parts = append(parts, name+"="+escapeRFC3986(content))
+		}
+	}
+	return strings.Join(parts, "&"), nil
+}
+
+// escapeRFC3986 percent-encodes everything but the RFC 3986 unreserved
+// characters, spaces become %20 rather than '+'.
+func escapeRFC3986(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// validateBody checks the body is well-formed for the declared Content-Type,
+// catching a malformed payload locally rather than through a cryptic 400.
+// Content types it knows nothing about are accepted as is.
+func validateBody(contentType, body string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid Content-Type %q: %s", contentType, err)
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal([]byte(body), &v); err != nil {
+			if syntaxErr, ok := err.(*json.SyntaxError); ok {
+				return fmt.Errorf("body is not valid JSON at offset %d: %s", syntaxErr.Offset, err)
+			}
+			return fmt.Errorf("body is not valid JSON: %s", err)
+		}
+	case mediaType == formURLEncoded:
+		if _, err := url.ParseQuery(body); err != nil {
+			return fmt.Errorf("body is not valid form data: %s", err)
+		}
+	}
+	return nil
+}
+
+// formPart is a -form text field or a -form-file file part of a
+// multipart/form-data body.
+type formPart struct {
+	name  string
+	value string // the text value, or the path of the file
+	file  bool
+}
+
+// formFlag is the flag.Value of -form and -form-file, both append to the
+// same list so parts keep the order they were given in on the command line.
+type formFlag struct {
+	parts *[]formPart
+	file  bool
+}
+
+func (f formFlag) String() string {
+	if f.parts == nil {
+		return ""
+	}
+	var items []string
+	for _, p := range *f.parts {
+		if p.file == f.file {
+			items = append(items, p.name)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (f formFlag) Set(value string) error {
+	var lines stringList
+	_ = lines.Set(value)
+	for _, line := range lines {
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid form field %q, expected name=value", line)
+		}
+		part := formPart{name: line[:i], value: line[i+1:], file: f.file}
+		if f.file {
+			if !strings.HasPrefix(part.value, "@") {
+				return fmt.Errorf("invalid form file %q, expected field=@path", line)
+			}
+			part.value = part.value[1:]
+		}
+		*f.parts = append(*f.parts, part)
+	}
+	return nil
+}
+
+// buildMultipartBody assembles a multipart/form-data body from the parts, in
+// order, and returns it with its Content-Type. An empty boundary picks a
+// random one. The type of a file part comes from its extension, or from its
+// content when detect is set.
+func buildMultipartBody(parts []formPart, boundary string, detect bool) (string, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if boundary != "" {
+		if err := w.SetBoundary(boundary); err != nil {
+			return "", "", err
+		}
+	}
+
+	for _, p := range parts {
+		if !p.file {
+			if err := w.WriteField(p.name, p.value); err != nil {
+				return "", "", err
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadFile(p.value)
+		if err != nil {
+			return "", "", err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(p.value))
+		if contentType == "" && detect {
+			contentType = detectContentType(data)
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(p.name), escapeQuotes(filepath.Base(p.value))))
+		h.Set("Content-Type", contentType)
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := pw.Write(data); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+	return buf.String(), w.FormDataContentType(), nil
+}
+
+// escapeQuotes escapes a Content-Disposition parameter the way mime/multipart
+// does.
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
+}
+
+// detectContentType guesses the media type of a body from its first 512 bytes
+// with http.DetectContentType, which knows images, PDFs, archives... but not
+// JSON, checked beforehand since it is the most common API payload.
+func detectContentType(body []byte) string {
+	if json.Valid(body) {
+		return "application/json"
+	}
+	if len(body) > 512 {
+		body = body[:512]
+	}
+	return http.DetectContentType(body)
+}
+
+// defaultHashBufferSize is the read buffer used to hash a -body-file.
+const defaultHashBufferSize = 64 * 1024
+
+// defaultMaxBodyBytes guards against sending a huge file by mistake, well
+// above the payload limits of Lambda (6 MB) and API Gateway (10 MB).
+const defaultMaxBodyBytes = 1 << 30
+
+// checkBodyFilesSize fails when the files of -name, concatenated, exceed max
+// bytes. The sizes are read from the file system so nothing is read before
+// the check, files such as pipes are only sized once sent. 0 disables it.
+func checkBodyFilesSize(name string, paths []string, max int64) error {
+	if max == 0 {
+		return nil
+	}
+	var size int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("error trying to read -%s %s", name, err)
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	if size > max {
+		return fmt.Errorf("-%s is %d bytes, more than the %d allowed by -max-body-bytes", name, size, max)
+	}
+	return nil
+}
+
+// fileBody is a -body-file body, the files concatenated in the order given.
+// It is hashed once up front, then streamed from disk by every request rather
+// than held in memory.
+type fileBody struct {
+	paths  []string
+	size   int64
+	sha256 string // hex encoded, the payload hash
+	md5    string // base64 encoded, the Content-MD5 value
+}
+
+// newFileBody hashes the files through a read buffer of the given size, large
+// buffers mean fewer reads on big payloads.
+func newFileBody(paths []string, bufferSize int) (*fileBody, error) {
+	if bufferSize <= 0 {
+		return nil, fmt.Errorf("invalid hash buffer size %d, must be positive", bufferSize)
+	}
+	// Opened up front so a missing part fails before anything is hashed
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	r := &lazyFiles{paths: paths}
+	defer r.Close()
+	sha := sha256.New()
+	sum := md5.New()
+	// Wrapped so io.CopyBuffer cannot bypass the buffer through ReadFrom/WriteTo
+	size, err := io.CopyBuffer(io.MultiWriter(sha, sum), struct{ io.Reader }{r}, make([]byte, bufferSize))
+	if err != nil {
+		return nil, err
+	}
+	return &fileBody{
+		paths:  paths,
+		size:   size,
+		sha256: hex.EncodeToString(sha.Sum(nil)),
+		md5:    base64.StdEncoding.EncodeToString(sum.Sum(nil)),
+	}, nil
+}
+
+// attach sets the files as the body of req. A file is only opened when the
+// body reaches it, and opened again when a redirect replays the body.
+func (b *fileBody) attach(req *http.Request) {
+	req.ContentLength = b.size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return &lazyFiles{paths: b.paths}, nil
+	}
+	req.Body, _ = req.GetBody()
+}
+
+// head returns the first bytes of the body, enough to detect its type.
+func (b *fileBody) head() ([]byte, error) {
+	r := &lazyFiles{paths: b.paths}
+	defer r.Close()
+	data := make([]byte, 512)
+	n, err := io.ReadFull(r, data)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+// lazyFiles reads files one after the other, each opened on its first read
+// and closed once read, so a single file is open at a time.
+type lazyFiles struct {
+	paths []string
+	file  *os.File
+}
+
+func (l *lazyFiles) Read(p []byte) (int, error) {
+	for {
+		if l.file == nil {
+			if len(l.paths) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(l.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			l.file, l.paths = f, l.paths[1:]
+		}
+		n, err := l.file.Read(p)
+		if err == io.EOF {
+			l.file.Close()
+			l.file = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (l *lazyFiles) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendURLEncodedData(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("from a file & more"), 0600))
+
+	tests := []struct {
+		body         string
+		items        []string
+		expectedBody string
+	}{
+		{"", []string{"name=John Doe"}, "name=John%20Doe"},
+		{"", []string{"q=a&b=c", "lang=fr~"}, "q=a%26b%3Dc&lang=fr~"},
+		{"", []string{"=raw value"}, "raw%20value"},
+		{"", []string{"just text"}, "just%20text"},
+		{"", []string{"email=me@example.com"}, "email=me%40example.com"},
+		{"", []string{"file@" + file}, "file=from%20a%20file%20%26%20more"},
+		{"a=1", []string{"b=2 3"}, "a=1&b=2%203"},
+	}
+
+	for _, test := range tests {
+		body, err := appendURLEncodedData(test.body, test.items)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.expectedBody, body, "unexpected body")
+	}
+
+	_, err := appendURLEncodedData("", []string{"@" + filepath.Join(t.TempDir(), "missing")})
+	assert.NotNil(t, err, "missing file should be reported")
+}
+
+func TestSignURLEncodedData(t *testing.T) {
+	body, err := appendURLEncodedData("", []string{"name=John Doe", "city=Lausanne"})
+	assert.Nil(t, err, "should not be any error")
+
+	req, bodyHash, _ := buildRequest("https://some-id.lambda-url.eu-west-1.on.aws/", "POST", "eu-west-1", body)
+	sum := sha256.Sum256([]byte("name=John%20Doe&city=Lausanne"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), bodyHash, "payload hash should cover the encoded body")
+
+	signer := v4.NewSigner()
+	err = signer.SignHTTP(context.Background(), testCredentials, req, bodyHash, "lambda", "eu-west-1", time.Unix(0, 0))
+	assert.Nil(t, err, "should not be any error")
+	assert.Contains(t, req.Header.Get("Authorization"), "Signature=")
+}
+
+func TestResolveMethod(t *testing.T) {
+	assert.Equal(t, "GET", resolveMethod("", false))
+	assert.Equal(t, "POST", resolveMethod("", true), "form data should imply a POST")
+	assert.Equal(t, "PUT", resolveMethod("PUT", true), "an explicit method should win")
+	assert.Equal(t, "GET", resolveMethod("GET", true), "an explicit GET should be kept")
+}
+
+func TestValidateBody(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+	}{
+		{"", "anything"},
+		{"application/json", `{"Test": "result"}`},
+		{"application/json; charset=utf-8", `[1, 2, 3]`},
+		{"application/problem+json", `{"title": "ok"}`},
+		{formURLEncoded, "a=1&b=2%203"},
+		{"text/plain", "{not json"},
+	}
+
+	for _, test := range tests {
+		assert.Nil(t, validateBody(test.contentType, test.body), "body should be valid")
+	}
+}
+
+func TestValidateInvalidBody(t *testing.T) {
+	tests := []struct {
+		contentType   string
+		body          string
+		expectedError string
+	}{
+		{"application/json", `{"Test": "result"`, "body is not valid JSON at offset 17: unexpected end of JSON input"},
+		{"application/json", `{"Test": 'result'}`, "body is not valid JSON at offset 10: invalid character '\\'' looking for beginning of value"},
+		{"application/json", "", "body is not valid JSON at offset 0: unexpected end of JSON input"},
+		{formURLEncoded, "a=%zz", `body is not valid form data: invalid URL escape "%zz"`},
+	}
+
+	for _, test := range tests {
+		assert.EqualError(t, validateBody(test.contentType, test.body), test.expectedError)
+	}
+}
+
+func TestFormFlagPreservesOrder(t *testing.T) {
+	var parts []formPart
+	form := formFlag{parts: &parts}
+	formFile := formFlag{parts: &parts, file: true}
+
+	assert.Nil(t, form.Set("title=report"))
+	assert.Nil(t, formFile.Set("attachment=@data.csv"))
+	assert.Nil(t, form.Set("tags=a\nnote=b=c\n"))
+	assert.Equal(t, []formPart{
+		{"title", "report", false},
+		{"attachment", "data.csv", true},
+		{"tags", "a", false},
+		{"note", "b=c", false},
+	}, parts)
+	assert.Equal(t, "title,tags,note", form.String())
+	assert.Equal(t, "attachment", formFile.String())
+
+	assert.EqualError(t, form.Set("novalue"), `invalid form field "novalue", expected name=value`)
+	assert.EqualError(t, formFile.Set("file=data.csv"), `invalid form file "file=data.csv", expected field=@path`)
+}
+
+func TestBuildMultipartBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"a":1}`), 0600))
+
+	body, contentType, err := buildMultipartBody([]formPart{
+		{"title", "report", false},
+		{"attachment", path, true},
+		{"note", "last", false},
+	}, "boundary", false)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "multipart/form-data; boundary=boundary", contentType)
+	assert.Equal(t, "--boundary\r\n"+
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n"+
+		"report\r\n"+
+		"--boundary\r\n"+
+		"Content-Disposition: form-data; name=\"attachment\"; filename=\"data.json\"\r\n"+
+		"Content-Type: application/json\r\n\r\n"+
+		"{\"a\":1}\r\n"+
+		"--boundary\r\n"+
+		"Content-Disposition: form-data; name=\"note\"\r\n\r\n"+
+		"last\r\n"+
+		"--boundary--\r\n", body)
+
+	_, _, err = buildMultipartBody([]formPart{{"attachment", filepath.Join(t.TempDir(), "missing"), true}}, "", false)
+	assert.NotNil(t, err, "a missing file should fail")
+}
+
+func TestBuildMultipartBodySigned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logo.png")
+	assert.Nil(t, ioutil.WriteFile(path, []byte{0x89, 'P', 'N', 'G'}, 0600))
+
+	body, contentType, err := buildMultipartBody([]formPart{
+		{"logo", path, true},
+		{"title", "report", false},
+	}, "", false)
+	assert.Nil(t, err, "should not be any error")
+
+	req, bodyHash, _ := buildRequest("https://example.lambda-url.eu-west-1.on.aws/", http.MethodPost, "eu-west-1", body)
+	req.Header.Set("Content-Type", contentType)
+	sum := sha256.Sum256([]byte(body))
+	assert.Equal(t, hex.EncodeToString(sum[:]), bodyHash, "the signed hash should cover the exact multipart body")
+	assert.Nil(t, v4.NewSigner().SignHTTP(context.Background(), testCredentials, req, bodyHash, "lambda", "eu-west-1", time.Now()))
+	assert.Contains(t, req.Header.Get("Authorization"), "content-type")
+
+	_, params, err := mime.ParseMediaType(contentType)
+	assert.Nil(t, err, "should not be any error")
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var names []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		names = append(names, part.FormName())
+	}
+	assert.Equal(t, []string{"logo", "title"}, names, "parts should keep their order")
+}
+
+func TestDetectContentType(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 1024)...)
+	tests := []struct {
+		body     []byte
+		expected string
+	}{
+		{[]byte(`{"Test": "result"}`), "application/json"},
+		{png, "image/png"},
+		{[]byte("%PDF-1.7\n"), "application/pdf"},
+		{[]byte("plain text"), "text/plain; charset=utf-8"},
+		{[]byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, detectContentType(test.body))
+	}
+}
+
+func TestBuildMultipartBodyDetect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("%PDF-1.7\n"), 0600))
+
+	for detect, expected := range map[bool]string{false: "application/octet-stream", true: "application/pdf"} {
+		body, _, err := buildMultipartBody([]formPart{{"scan", path, true}}, "boundary", detect)
+		assert.Nil(t, err, "should not be any error")
+		assert.Contains(t, body, "Content-Type: "+expected+"\r\n")
+	}
+}
+
+func TestNewFileBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payload.json")
+	content := []byte(strings.Repeat(`{"Test": "result"}`, 1000))
+	assert.Nil(t, ioutil.WriteFile(path, content, 0600))
+
+	sum := sha256.Sum256(content)
+	digest := md5.Sum(content)
+	for _, bufferSize := range []int{1, 7, defaultHashBufferSize} {
+		file, err := newFileBody([]string{path}, bufferSize)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, int64(len(content)), file.size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), file.sha256)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(digest[:]), file.md5)
+	}
+
+	file, _ := newFileBody([]string{path}, defaultHashBufferSize)
+	req, _, _ := buildRequest("https://example.com/", http.MethodPut, "eu-west-1", "")
+	file.attach(req)
+	for i := 0; i < 2; i++ {
+		data, err := ioutil.ReadAll(req.Body)
+		assert.Nil(t, err, "should not be any error")
+		assert.Nil(t, req.Body.Close())
+		assert.Equal(t, content, data, "every read of the body should stream the whole file")
+		req.Body, _ = req.GetBody()
+	}
+
+	head, err := file.head()
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, content[:512], head)
+
+	_, err = newFileBody([]string{path}, 0)
+	assert.EqualError(t, err, "invalid hash buffer size 0, must be positive")
+	_, err = newFileBody([]string{path, filepath.Join(t.TempDir(), "missing")}, defaultHashBufferSize)
+	assert.NotNil(t, err, "a missing file should fail")
+}
+
+func TestNewFileBodyConcatenated(t *testing.T) {
+	dir := t.TempDir()
+	parts := []string{`{"header": 1,`, "", ` "items": [` + strings.Repeat(`"x",`, 200) + `"y"]}`}
+	var paths []string
+	for i, part := range parts {
+		path := filepath.Join(dir, strconv.Itoa(i))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(part), 0600))
+		paths = append(paths, path)
+	}
+	content := []byte(strings.Join(parts, ""))
+
+	sum := sha256.Sum256(content)
+	for _, bufferSize := range []int{1, 7, defaultHashBufferSize} {
+		file, err := newFileBody(paths, bufferSize)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, int64(len(content)), file.size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), file.sha256, "the hash should cover the files in order")
+	}
+
+	file, _ := newFileBody(paths, defaultHashBufferSize)
+	req, _, _ := buildRequest("https://example.com/", http.MethodPut, "eu-west-1", "")
+	file.attach(req)
+	data, err := ioutil.ReadAll(req.Body)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, content, data)
+	head, err := file.head()
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, content[:512], head, "the head should span the files")
+}
+
+func BenchmarkNewFileBody(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "large")
+	if err := ioutil.WriteFile(path, bytes.Repeat([]byte("0123456789abcdef"), 4<<20/16*8), 0600); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bufferSize := range []int{4 << 10, 32 << 10, defaultHashBufferSize, 1 << 20} {
+		b.Run(strconv.Itoa(bufferSize>>10)+"KB", func(b *testing.B) {
+			b.SetBytes(32 << 20)
+			for i := 0; i < b.N; i++ {
+				if _, err := newFileBody([]string{path}, bufferSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBodyFilesSize(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	assert.Nil(t, ioutil.WriteFile(first, make([]byte, 600), 0600))
+	assert.Nil(t, ioutil.WriteFile(second, make([]byte, 500), 0600))
+
+	assert.Nil(t, checkBodyFilesSize("body-file", []string{first, second}, 1100))
+	assert.Nil(t, checkBodyFilesSize("body-file", []string{first, second}, 0), "0 should disable the check")
+	assert.EqualError(t, checkBodyFilesSize("body-file", []string{first, second}, 1000), "-body-file is 1100 bytes, more than the 1000 allowed by -max-body-bytes")
+	assert.EqualError(t, checkBodyFilesSize("raw-body-file", []string{first}, 100), "-raw-body-file is 600 bytes, more than the 100 allowed by -max-body-bytes")
+
+	err := checkBodyFilesSize("body-file", []string{filepath.Join(dir, "missing")}, 1000)
+	assert.Contains(t, err.Error(), "error trying to read -body-file")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheMaxEntries bounds the number of URLs a -cache run remembers.
+const cacheMaxEntries = 100
+
+// cacheEntry is what is remembered of a GET response: enough to answer while
+// it is fresh and to revalidate it with a conditional request afterwards.
+type cacheEntry struct {
+	status       int
+	etag         string
+	lastModified string
+	expires      time.Time
+}
+
+// responseCache is an in-memory cache of GET responses keyed by URL, honoring
+// Cache-Control and the ETag/Last-Modified validators. It only lives for the
+// run, the oldest entry is evicted when full.
+type responseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]cacheEntry
+	order      []string
+	now        func() time.Time
+}
+
+func newResponseCache(maxEntries int) *responseCache {
+	return &responseCache{maxEntries: maxEntries, entries: map[string]cacheEntry{}, now: time.Now}
+}
+
+// fresh returns the status of a cached response that can be reused without
+// contacting the endpoint.
+func (c *responseCache) fresh(url string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[url]
+	if !ok || !c.now().Before(entry.expires) {
+		return 0, false
+	}
+	return entry.status, true
+}
+
+// addValidators turns req into a conditional request when a stale entry has
+// validators. It must be called before signing so the headers are signed.
+func (c *responseCache) addValidators(req *http.Request) {
+	if req.Method != http.MethodGet {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[req.URL.String()]
+	if !ok {
+		return
+	}
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+	if entry.lastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+}
+
+// update records the response of req and returns the status to report: a
+// 304 Not Modified stands for the status of the entry it revalidated.
+func (c *responseCache) update(req *http.Request, resp *http.Response) int {
+	if req.Method != http.MethodGet {
+		return resp.StatusCode
+	}
+	key := req.URL.String()
+	maxAge, store := cacheMaxAge(resp.Header.Get("Cache-Control"))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if resp.StatusCode == http.StatusNotModified {
+		entry, ok := c.entries[key]
+		if !ok {
+			return resp.StatusCode
+		}
+		entry.expires = c.now().Add(maxAge)
+		c.entries[key] = entry
+		return entry.status
+	}
+
+	if resp.StatusCode != http.StatusOK || !store {
+		c.remove(key)
+		return resp.StatusCode
+	}
+	entry := cacheEntry{
+		status:       resp.StatusCode,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		expires:      c.now().Add(maxAge),
+	}
+	if maxAge == 0 && entry.etag == "" && entry.lastModified == "" {
+		// Neither fresh nor revalidable, nothing worth keeping
+		c.remove(key)
+		return resp.StatusCode
+	}
+	if _, ok := c.entries[key]; !ok {
+		if len(c.order) >= c.maxEntries {
+			c.remove(c.order[0])
+		}
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = entry
+	return resp.StatusCode
+}
+
+func (c *responseCache) remove(key string) {
+	if _, ok := c.entries[key]; !ok {
+		return
+	}
+	delete(c.entries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// cacheMaxAge returns how long a response stays fresh according to its
+// Cache-Control header, and whether it may be stored at all.
+func cacheMaxAge(cacheControl string) (time.Duration, bool) {
+	var maxAge time.Duration
+	noCache := false
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return 0, false
+		case directive == "no-cache":
+			noCache = true
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.Trim(directive[len("max-age="):], `"`)); err == nil && seconds > 0 {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if noCache {
+		// Stored for its validators only, every use is revalidated
+		return 0, true
+	}
+	return maxAge, true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheMaxAge(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		maxAge       time.Duration
+		store        bool
+	}{
+		{"", 0, true},
+		{"max-age=60", time.Minute, true},
+		{"public, Max-Age=30", 30 * time.Second, true},
+		{"max-age=60, no-cache", 0, true},
+		{"no-store, max-age=60", 0, false},
+		{"max-age=soon", 0, true},
+	}
+	for _, test := range tests {
+		maxAge, store := cacheMaxAge(test.cacheControl)
+		assert.Equal(t, test.maxAge, maxAge, test.cacheControl)
+		assert.Equal(t, test.store, store, test.cacheControl)
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := newResponseCache(cacheMaxEntries)
+	cache.now = func() time.Time { return now }
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/health", nil)
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=10"}, "Etag": {`"v1"`}}}
+	assert.Equal(t, http.StatusOK, cache.update(req, resp))
+
+	status, ok := cache.fresh("https://example.com/health")
+	assert.True(t, ok, "the response should be fresh")
+	assert.Equal(t, http.StatusOK, status)
+
+	now = now.Add(10 * time.Second)
+	_, ok = cache.fresh("https://example.com/health")
+	assert.False(t, ok, "the response should be stale after max-age")
+
+	conditional := httptest.NewRequest(http.MethodGet, "https://example.com/health", nil)
+	cache.addValidators(conditional)
+	assert.Equal(t, `"v1"`, conditional.Header.Get("If-None-Match"))
+
+	notModified := &http.Response{StatusCode: http.StatusNotModified, Header: http.Header{"Cache-Control": {"max-age=10"}}}
+	assert.Equal(t, http.StatusOK, cache.update(conditional, notModified), "a 304 should report the revalidated status")
+	_, ok = cache.fresh("https://example.com/health")
+	assert.True(t, ok, "the revalidated response should be fresh again")
+
+	failed := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	assert.Equal(t, http.StatusServiceUnavailable, cache.update(req, failed))
+	_, ok = cache.fresh("https://example.com/health")
+	assert.False(t, ok, "an error should drop the entry")
+}
+
+func TestResponseCacheIgnoresUncacheable(t *testing.T) {
+	cache := newResponseCache(cacheMaxEntries)
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+
+	cache.update(req, &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"no-store"}, "Etag": {`"v1"`}}})
+	cache.update(req, &http.Response{StatusCode: http.StatusOK, Header: http.Header{}})
+	assert.Empty(t, cache.entries)
+
+	post := httptest.NewRequest(http.MethodPost, "https://example.com/", nil)
+	cache.update(post, &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=60"}}})
+	assert.Empty(t, cache.entries)
+}
+
+func TestResponseCacheIsBounded(t *testing.T) {
+	cache := newResponseCache(2)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com"+path, nil)
+		cache.update(req, &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=60"}}})
+	}
+	assert.Len(t, cache.entries, 2)
+	_, ok := cache.fresh("https://example.com/a")
+	assert.False(t, ok, "the oldest entry should be evicted")
+	_, ok = cache.fresh("https://example.com/c")
+	assert.True(t, ok)
+}
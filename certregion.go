@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// certRegionTimeout bounds the preliminary handshake of -cert-region.
+const certRegionTimeout = 5 * time.Second
+
+// certificateRegion reads the region from the DNS names of the certificate
+// served for lambdaURL, through a TLS handshake made before signing. It is a
+// best-effort last resort for custom domains: it only works when the
+// certificate also covers an AWS name such as
+// *.execute-api.eu-west-1.amazonaws.com. config may be nil to use the system
+// roots, the certificate is verified as for the request itself.
+func certificateRegion(ctx context.Context, lambdaURL string, config *tls.Config) (string, error) {
+	u, err := url.Parse(lambdaURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("no certificate to read the region from, %s is not an https URL", u.Redacted())
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	config.ServerName = u.Hostname()
+
+	ctx, cancel := context.WithTimeout(ctx, certRegionTimeout)
+	defer cancel()
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return "", fmt.Errorf("error reading the certificate of %s: %s", u.Host, err)
+	}
+	defer conn.Close()
+
+	leaf := conn.(*tls.Conn).ConnectionState().PeerCertificates[0]
+	r := regexp.MustCompile(awsRegionRegExp)
+	for _, name := range leaf.DNSNames {
+		if result := r.FindStringSubmatch(strings.ToLower(name)); result != nil {
+			return result[1], nil
+		}
+	}
+	return "", fmt.Errorf("no region in the names of the certificate of %s: %s", u.Host, strings.Join(leaf.DNSNames, ", "))
+}
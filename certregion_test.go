@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newCertServer starts a TLS server whose self-signed certificate covers
+// dnsNames, returning it with a config trusting that certificate.
+func newCertServer(t *testing.T, dnsNames []string) (*httptest.Server, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err, "should not be any error")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err, "should not be any error")
+	cert, _ := x509.ParseCertificate(der)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// The handshakes rejected on purpose are not worth logging
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return server, &tls.Config{RootCAs: roots}
+}
+
+func TestCertificateRegion(t *testing.T) {
+	server, config := newCertServer(t, []string{"api.example.com", "*.execute-api.eu-west-2.amazonaws.com"})
+	defer server.Close()
+
+	region, err := certificateRegion(context.Background(), server.URL+"/path", config)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "eu-west-2", region)
+
+	_, err = certificateRegion(context.Background(), server.URL, nil)
+	assert.NotNil(t, err, "an untrusted certificate should not be read")
+}
+
+func TestCertificateRegionWithoutAWSName(t *testing.T) {
+	server, config := newCertServer(t, []string{"api.example.com"})
+	defer server.Close()
+
+	_, err := certificateRegion(context.Background(), server.URL, config)
+	assert.EqualError(t, err, "no region in the names of the certificate of "+server.Listener.Addr().String()+": api.example.com")
+
+	_, err = certificateRegion(context.Background(), "http://api.example.com/", config)
+	assert.EqualError(t, err, "no certificate to read the region from, http://api.example.com/ is not an https URL")
+}
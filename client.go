@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Values of the error output, telling apart why a request failed.
+const (
+	errorDNS                = "dns"
+	errorTimeout            = "timeout"
+	errorConnectionRefused  = "connection_refused"
+	errorRequest            = "request_failed"
+	errorPreconditionFailed = "precondition_failed"
+	errorHeaderMismatch     = "header_mismatch"
+	errorLatencyExceeded    = "latency_exceeded"
+	errorContentType        = "content_type_mismatch"
+	errorSchemaMismatch     = "schema_mismatch"
+	errorGoldenMismatch     = "golden_mismatch"
+)
+
+// defaultConnectTimeout bounds establishing a connection unless
+// -connect-timeout says otherwise.
+const defaultConnectTimeout = 30 * time.Second
+
+// transportOptions gathers the flags tuning how connections are established.
+type transportOptions struct {
+	ipVersion           string
+	maxIdleConnsPerHost int
+	disableCompression  bool
+	unixSocket          string
+	connectTimeout      time.Duration
+	disableKeepAlives   bool
+	dnsServer           string
+}
+
+// dialNetwork maps the -ip-version flag to the network passed to the dialer.
+func dialNetwork(ipVersion string) (string, error) {
+	switch ipVersion {
+	case "", "auto":
+		return "tcp", nil
+	case "4":
+		return "tcp4", nil
+	case "6":
+		return "tcp6", nil
+	}
+	return "", fmt.Errorf("invalid ip version %q, expected 4, 6 or auto", ipVersion)
+}
+
+// dnsServerAddress validates the value of -dns-server, a host with an
+// optional port defaulting to 53.
+func dnsServerAddress(server string) (string, error) {
+	if server == "" {
+		return "", nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		// No port, possibly a bare IPv6 address
+		host, port = strings.Trim(server, "[]"), "53"
+	}
+	if host == "" || strings.ContainsAny(server, "/ ") {
+		return "", fmt.Errorf("invalid DNS server %q, expected a host with an optional port such as 10.0.0.2 or 10.0.0.2:53", server)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid DNS server %q, the port must be between 1 and 65535", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// newResolver returns a resolver sending every query to the DNS server at
+// address rather than to the ones of the system configuration.
+func newResolver(address string) *net.Resolver {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// classifyRequestError maps the error of a request without response to the
+// error output, along with a message pointing at the likely cause.
+func classifyRequestError(err error) (string, string) {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return errorDNS, fmt.Sprintf("could not resolve host %s, check the URL and the network of the runner", dnsErr.Name)
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout() {
+		return errorTimeout, fmt.Sprintf("the connection timed out, check the endpoint is reachable or raise -connect-timeout (%s)", err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errorTimeout, fmt.Sprintf("the request timed out, check the endpoint is reachable or raise -timeout (%s)", err)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return errorConnectionRefused, fmt.Sprintf("the connection was refused, check the port of the URL and that the endpoint is up (%s)", err)
+	}
+	return errorRequest, fmt.Sprintf("HTTP error %s", err)
+}
+
+// newTransport returns a pooled transport, based on the default one, shared by
+// every request of the run.
+func newTransport(opts transportOptions) (*wireTransport, error) {
+	network, err := dialNetwork(opts.ipVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	connectTimeout := opts.connectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	if opts.dnsServer != "" {
+		// Split-horizon setups only resolve private endpoints through their own server
+		dialer.Resolver = newResolver(opts.dnsServer)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.maxIdleConnsPerHost
+	// gzip is requested by wireTransport and undone by decodeContentEncoding,
+	// a body decompressed by the transport could not be counted as transferred
+	transport.DisableCompression = true
+	// A fresh connection per request, for proxies mishandling reuse
+	transport.DisableKeepAlives = opts.disableKeepAlives
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	if opts.unixSocket != "" {
+		// The URL still names the host the request is signed for, only the
+		// connection goes to the socket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", opts.unixSocket)
+		}
+	}
+	return &wireTransport{Transport: transport, compress: !opts.disableCompression}, nil
+}
+
+// wireTransport counts the bytes of the bodies as they go over the wire: the
+// request body as the transport reads it, streamed or chunked bodies with an
+// unknown Content-Length included, and the response body before it is
+// decompressed. Like the transport it wraps, it asks for gzip unless the
+// request names its own Accept-Encoding, but leaves the decoding to the reader
+// of the body.
+type wireTransport struct {
+	*http.Transport
+	compress bool
+
+	mu   sync.Mutex
+	sent *countingBody
+}
+
+func (t *wireTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var sent *countingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		sent = &countingBody{ReadCloser: req.Body}
+	}
+	compress := t.compress && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != http.MethodHead
+	if sent != nil || compress {
+		// The request of the caller is left untouched
+		req = req.Clone(req.Context())
+		if sent != nil {
+			req.Body = sent
+		}
+		if compress {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+	}
+	t.mu.Lock()
+	t.sent = sent
+	t.mu.Unlock()
+	return t.Transport.RoundTrip(req)
+}
+
+// bytesSent is the number of body bytes sent with the last request, the one
+// that got the response when redirects were followed (a 303 drops the body).
+func (t *wireTransport) bytesSent() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sent == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&t.sent.n)
+}
+
+// countingBody counts the bytes of a request body read by the transport, from
+// the goroutine writing the request.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// redirectHop is a redirect response followed on the way to the final one.
+type redirectHop struct {
+	URL      string `json:"url"`
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+}
+
+// redirectPolicy follows up to max redirects and records the chain. A
+// signature only covers the URL it was computed for, so a hop to the host of
+// the request over HTTPS is signed again through sign with the payload hash of
+// the body it carries. Any other host gets the hop without credentials, so
+// that an endpoint cannot redirect a signed request to another API, and a
+// downgrade from HTTPS to HTTP is refused.
+type redirectPolicy struct {
+	max         int
+	payloadHash string
+	sign        func(req *http.Request, payloadHash string)
+
+	mu   sync.Mutex
+	hops []redirectHop
+}
+
+// checkRedirect is the CheckRedirect hook of the client.
+func (p *redirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if p.max <= 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > p.max {
+		return fmt.Errorf("stopped after %d redirects", p.max)
+	}
+	if from := via[len(via)-1].URL; from.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refused the redirect from %s to %s, a downgrade from HTTPS", from, req.URL)
+	}
+
+	p.mu.Lock()
+	p.hops = append(p.hops, redirectHop{URL: via[len(via)-1].URL.String(), Status: req.Response.StatusCode, Location: req.URL.String()})
+	p.mu.Unlock()
+
+	// 301, 302 and 303 turn the request into a bodyless GET, 307 and 308 replay
+	// the body
+	payloadHash := emptyPayloadHash
+	if req.Body != nil && req.Body != http.NoBody {
+		payloadHash = p.payloadHash
+	}
+	for _, name := range []string{"Authorization", "X-Amz-Date", "X-Amz-Security-Token", "X-Amz-Content-Sha256"} {
+		req.Header.Del(name)
+	}
+	if req.URL.Scheme == "https" && req.URL.Host == via[0].URL.Host {
+		p.sign(req, payloadHash)
+	}
+	return nil
+}
+
+// chain returns the redirects followed so far.
+func (p *redirectPolicy) chain() []redirectHop {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]redirectHop(nil), p.hops...)
+}
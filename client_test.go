@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialNetwork(t *testing.T) {
+	tests := []struct {
+		ipVersion       string
+		expectedNetwork string
+	}{
+		{"", "tcp"},
+		{"auto", "tcp"},
+		{"4", "tcp4"},
+		{"6", "tcp6"},
+	}
+
+	for _, test := range tests {
+		network, err := dialNetwork(test.ipVersion)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.expectedNetwork, network, "unexpected network")
+	}
+
+	_, err := dialNetwork("5")
+	assert.EqualError(t, err, `invalid ip version "5", expected 4, 6 or auto`)
+}
+
+func TestTransportIPVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	transport, err := newTransport(transportOptions{ipVersion: "4"})
+	assert.Nil(t, err, "should not be any error")
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	assert.Nil(t, err, "IPv4 server should be reachable over IPv4")
+	resp.Body.Close()
+
+	transport, err = newTransport(transportOptions{ipVersion: "6"})
+	assert.Nil(t, err, "should not be any error")
+	_, err = (&http.Client{Transport: transport}).Get(server.URL)
+	assert.NotNil(t, err, "IPv4 server should not be reachable over IPv6")
+}
+
+func TestTransportDisableKeepAlives(t *testing.T) {
+	var mu sync.Mutex
+	remotes := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		remotes[r.RemoteAddr] = true
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		disable     bool
+		connections int
+	}{
+		{false, 1},
+		{true, 3},
+	} {
+		remotes = map[string]bool{}
+		transport, err := newTransport(transportOptions{disableKeepAlives: test.disable})
+		assert.Nil(t, err, "should not be any error")
+		client := &http.Client{Transport: transport}
+		for i := 0; i < 3; i++ {
+			resp, err := client.Get(server.URL)
+			assert.Nil(t, err, "should not be any error")
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		assert.Len(t, remotes, test.connections, "disable keep-alives: %t", test.disable)
+	}
+}
+
+func TestWireTransportCounts(t *testing.T) {
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write([]byte(strings.Repeat("result ", 100)))
+	gw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if r.Header.Get("Accept-Encoding") == "gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed.Bytes())
+			return
+		}
+		w.Write([]byte("plain"))
+	}))
+	defer server.Close()
+
+	transport, err := newTransport(transportOptions{})
+	assert.Nil(t, err, "should not be any error")
+	client := &http.Client{Transport: transport}
+
+	// A reader of unknown length, sent chunked
+	req, _ := http.NewRequest(http.MethodPost, server.URL, ioutil.NopCloser(strings.NewReader(strings.Repeat("a", 5000))))
+	assert.Equal(t, int64(0), req.ContentLength)
+	resp, err := client.Do(req)
+	assert.Nil(t, err, "should not be any error")
+	defer resp.Body.Close()
+	assert.Equal(t, int64(5000), transport.bytesSent(), "a streamed body should be counted as sent")
+	assert.Empty(t, req.Header.Get("Accept-Encoding"), "the request of the caller should be left untouched")
+
+	received := &countingReader{r: resp.Body}
+	r, err := decodeContentEncoding(resp.Header.Get("Content-Encoding"), received)
+	assert.Nil(t, err, "should not be any error")
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, strings.Repeat("result ", 100), string(body), "gzip should be requested and decoded")
+	assert.Equal(t, int64(compressed.Len()), received.n, "the compressed size should be counted")
+
+	resp, err = client.Get(server.URL)
+	assert.Nil(t, err, "should not be any error")
+	resp.Body.Close()
+	assert.Equal(t, int64(0), transport.bytesSent(), "a request without body should send nothing")
+}
+
+func TestRedirectPolicyLimit(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	}))
+	defer server.Close()
+
+	var signed int
+	policy := &redirectPolicy{max: 2, payloadHash: emptyPayloadHash, sign: func(req *http.Request, payloadHash string) {
+		assert.Equal(t, emptyPayloadHash, payloadHash)
+		signed++
+	}}
+	client := server.Client()
+	client.CheckRedirect = policy.checkRedirect
+	_, err := client.Get(server.URL)
+	assert.Contains(t, err.Error(), "stopped after 2 redirects")
+	assert.Equal(t, 2, signed, "every followed hop should be signed")
+	assert.Len(t, policy.chain(), 2)
+}
+
+// signedGet sends a GET carrying credentials, as signed for the first server.
+func signedGet(client *http.Client, url string) (*http.Response, error) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKID/20240102/eu-west-1/lambda/aws4_request")
+	req.Header.Set("X-Amz-Date", "20240102T030405Z")
+	req.Header.Set("X-Amz-Security-Token", "SESSION")
+	return client.Do(req)
+}
+
+func TestRedirectPolicyCrossHost(t *testing.T) {
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"Authorization", "X-Amz-Date", "X-Amz-Security-Token"} {
+			assert.Empty(t, r.Header.Get(name), "%s should not be sent to another host", name)
+		}
+		w.Write([]byte("other"))
+	}))
+	defer other.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/elsewhere", http.StatusFound)
+	}))
+	defer server.Close()
+
+	var signed int
+	policy := &redirectPolicy{max: 10, payloadHash: emptyPayloadHash, sign: func(req *http.Request, payloadHash string) {
+		signed++
+	}}
+	client := server.Client()
+	client.CheckRedirect = policy.checkRedirect
+	resp, err := signedGet(client, server.URL)
+	assert.Nil(t, err, "should not be any error")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "other", string(body))
+	assert.Equal(t, 0, signed, "a hop to another host should not be signed")
+	assert.Len(t, policy.chain(), 1)
+}
+
+func TestRedirectPolicySameHost(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			assert.Equal(t, "signed again", r.Header.Get("Authorization"))
+			return
+		}
+		http.Redirect(w, r, server.URL+"/moved", http.StatusFound)
+	}))
+	defer server.Close()
+
+	policy := &redirectPolicy{max: 10, payloadHash: emptyPayloadHash, sign: func(req *http.Request, payloadHash string) {
+		assert.Empty(t, req.Header.Get("X-Amz-Security-Token"), "the previous signature should be removed")
+		req.Header.Set("Authorization", "signed again")
+	}}
+	client := server.Client()
+	client.CheckRedirect = policy.checkRedirect
+	resp, err := signedGet(client, server.URL)
+	assert.Nil(t, err, "should not be any error")
+	resp.Body.Close()
+}
+
+func TestRedirectPolicyDowngrade(t *testing.T) {
+	var hit bool
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer plain.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL, http.StatusFound)
+	}))
+	defer server.Close()
+
+	policy := &redirectPolicy{max: 10, payloadHash: emptyPayloadHash, sign: func(req *http.Request, payloadHash string) {
+		t.Error("a downgraded hop should not be signed")
+	}}
+	client := server.Client()
+	client.CheckRedirect = policy.checkRedirect
+	_, err := signedGet(client, server.URL)
+	if assert.NotNil(t, err, "the downgrade should be refused") {
+		assert.Contains(t, err.Error(), "refused the redirect from "+server.URL+" to "+plain.URL)
+	}
+	assert.False(t, hit, "the HTTP endpoint should not be called")
+}
+
+func TestClassifyRequestError(t *testing.T) {
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+	_, refusedErr := http.Get(refused.URL)
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	_, timeoutErr := (&http.Client{Timeout: 20 * time.Millisecond}).Get(slow.URL)
+
+	dnsErr := &url.Error{Op: "Get", URL: "https://unknown.invalid/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "unknown.invalid"}}}
+
+	tests := []struct {
+		err          error
+		expectedKind string
+	}{
+		{dnsErr, errorDNS},
+		{timeoutErr, errorTimeout},
+		{refusedErr, errorConnectionRefused},
+		{errors.New("unsupported protocol scheme"), errorRequest},
+	}
+	for _, test := range tests {
+		kind, _ := classifyRequestError(test.err)
+		assert.Equal(t, test.expectedKind, kind, "%v", test.err)
+	}
+
+	_, msg := classifyRequestError(dnsErr)
+	assert.Equal(t, "could not resolve host unknown.invalid, check the URL and the network of the runner", msg)
+}
+
+// newDNSServer serves A queries over UDP, answering 127.0.0.1 for the names in
+// hosts and with no record otherwise. It returns the address of the server.
+func newDNSServer(t *testing.T, hosts ...string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err, "should not be any error")
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			// The question follows the 12 bytes header: labels then type and class
+			end := 12
+			var labels []string
+			for end < n && query[end] != 0 {
+				labels = append(labels, string(query[end+1:end+1+int(query[end])]))
+				end += 1 + int(query[end])
+			}
+			end += 5
+			if end > n {
+				continue
+			}
+			qtype := int(query[end-4])<<8 | int(query[end-3])
+			name := strings.Join(labels, ".")
+			known := false
+			for _, host := range hosts {
+				known = known || host == name
+			}
+
+			resp := append([]byte{query[0], query[1], 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}, query[12:end]...)
+			if known && qtype == 1 {
+				resp[7] = 1
+				resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestTransportDNSServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	dns := newDNSServer(t, "my-function.internal.example")
+	transport, err := newTransport(transportOptions{ipVersion: "4", dnsServer: dns})
+	assert.Nil(t, err, "should not be any error")
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get("http://my-function.internal.example:" + port + "/")
+	assert.Nil(t, err, "the private name should resolve through the DNS server")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "my-function.internal.example:"+port, string(body))
+
+	_, err = client.Get("http://unknown.internal.example:" + port + "/")
+	kind, _ := classifyRequestError(err)
+	assert.Equal(t, errorDNS, kind)
+}
+
+func TestDNSServerAddress(t *testing.T) {
+	tests := []struct {
+		server, address string
+	}{
+		{"", ""},
+		{"10.0.0.2", "10.0.0.2:53"},
+		{"10.0.0.2:5353", "10.0.0.2:5353"},
+		{"dns.internal", "dns.internal:53"},
+		{"fd00::2", "[fd00::2]:53"},
+		{"[fd00::2]:5353", "[fd00::2]:5353"},
+	}
+	for _, test := range tests {
+		address, err := dnsServerAddress(test.server)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.address, address, test.server)
+	}
+
+	_, err := dnsServerAddress("10.0.0.2:99999")
+	assert.EqualError(t, err, `invalid DNS server "10.0.0.2:99999", the port must be between 1 and 65535`)
+	_, err = dnsServerAddress("udp://10.0.0.2")
+	assert.EqualError(t, err, `invalid DNS server "udp://10.0.0.2", expected a host with an optional port such as 10.0.0.2 or 10.0.0.2:53`)
+}
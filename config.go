@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Where the signing credentials of a run come from, as printed by
+// -print-config.
+const (
+	credentialSourceNone      = "none"
+	credentialSourceEnv       = "env"
+	credentialSourceTokenFile = "env+session-token-file"
+	credentialSourceProfiles  = "shared-credentials-file"
+	credentialSourceProcess   = "credentials-process"
+	// Appended to the source of the credentials assuming the role
+	credentialSourceAssumeRole = "+assume-role"
+)
+
+// resolvedConfig is the configuration of a run printed by -print-config once
+// every flag, env variable and default is applied. It holds no secret:
+// headers are listed by name and credentials by source, the access key ID
+// masked.
+type resolvedConfig struct {
+	URL              string   `json:"url"`
+	Method           string   `json:"method"`
+	Region           string   `json:"region"`
+	Service          string   `json:"service"`
+	Timeout          string   `json:"timeout"`
+	TotalTimeout     string   `json:"total_timeout"`
+	Retries          int      `json:"retries"`
+	RetryOnStatus    string   `json:"retry_on_status"`
+	Headers          []string `json:"headers"`
+	BodyBytes        int64    `json:"body_bytes"`
+	CredentialSource string   `json:"credential_source"`
+	AccessKeyID      string   `json:"access_key_id,omitempty"`
+}
+
+// maskAccessKeyID keeps the prefix telling the kind of key and the last 4
+// characters of an access key ID.
+func maskAccessKeyID(id string) string {
+	if len(id) <= 8 {
+		return strings.Repeat("*", len(id))
+	}
+	return id[:4] + strings.Repeat("*", len(id)-8) + id[len(id)-4:]
+}
+
+// printConfig writes the configuration as a single JSON line, scrubbed of the
+// registered secrets should one hide in the URL.
+func printConfig(w io.Writer, config resolvedConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, logger.scrub(string(data)))
+	return err
+}
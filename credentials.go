@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// tokenFileProvider provides the credentials of the env variables with the
+// session token of -session-token-file, read again on every Retrieve so a
+// token rotated by an OIDC flow during the run is picked up.
+type tokenFileProvider struct {
+	credentials aws.Credentials
+	path        string
+}
+
+func (p tokenFileProvider) Retrieve(context.Context) (aws.Credentials, error) {
+	token, err := readSessionToken(p.path)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	credentials := p.credentials
+	credentials.SessionToken = token
+	return credentials, nil
+}
+
+// refreshedCredentials retrieves the credentials of provider before every
+// signature for -refresh-credentials, new secrets are scrubbed from the logs.
+// When that fails, e.g. while the token file is being rewritten, the last
+// credentials retrieved are used.
+type refreshedCredentials struct {
+	provider aws.CredentialsProvider
+	mu       sync.Mutex
+	last     aws.Credentials
+}
+
+func (c *refreshedCredentials) get(ctx context.Context) aws.Credentials {
+	credentials, err := c.provider.Retrieve(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		logger.Warn(fmt.Sprintf("error refreshing the credentials %s, signing with the last ones", err), nil)
+		return c.last
+	}
+	if credentials.SessionToken != c.last.SessionToken || credentials.SecretAccessKey != c.last.SecretAccessKey {
+		logger.addSecret(credentials.SecretAccessKey)
+		logger.addSecret(credentials.SessionToken)
+		logger.Debug("credentials refreshed, they changed since the last signature", nil)
+	}
+	c.last = credentials
+	return credentials
+}
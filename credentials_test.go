@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("FIRST\n"), 0600))
+	provider := tokenFileProvider{aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, path}
+
+	credentials, err := provider.Retrieve(context.Background())
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", SessionToken: "FIRST"}, credentials)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("SECOND"), 0600))
+	credentials, _ = provider.Retrieve(context.Background())
+	assert.Equal(t, "SECOND", credentials.SessionToken, "the file should be read again")
+}
+
+func TestRefreshedCredentials(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	defer func(l *actionLogger) { logger = l }(logger)
+	logger = newLogger(logFormatText, true, &stdout, &stderr)
+
+	path := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("ROTATED"), 0600))
+	refreshed := &refreshedCredentials{provider: tokenFileProvider{testCredentials, path}, last: testCredentials}
+	assert.Equal(t, "ROTATED", refreshed.get(context.Background()).SessionToken)
+	logger.Info("token ROTATED", nil)
+	assert.NotContains(t, stdout.String()+stderr.String(), "ROTATED", "a refreshed token should be scrubbed from the logs")
+
+	// Half-written or gone, the last token read is kept
+	assert.Nil(t, os.Remove(path))
+	assert.Equal(t, "ROTATED", refreshed.get(context.Background()).SessionToken)
+	assert.Contains(t, stderr.String(), "error refreshing the credentials")
+}
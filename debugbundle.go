@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/smithy-go/logging"
+)
+
+// debugBundle collects what -debug-bundle writes on failure, filled in as the
+// run goes: a failure before the request is sent leaves the later parts out.
+// With -repeat the request is the last one signed.
+type debugBundle struct {
+	mu      sync.Mutex
+	start   time.Time
+	config  *resolvedConfig
+	signing string
+	request *http.Request
+	// exchange is only set once a response came back
+	exchange *harExchange
+	// log is a copy of the log lines, written by the logger under its lock
+	log bytes.Buffer
+}
+
+func newDebugBundle(now time.Time) *debugBundle {
+	return &debugBundle{start: now}
+}
+
+// debugTiming is the timing.json of the bundle, Request only set when a
+// response came back.
+type debugTiming struct {
+	StartedAt  time.Time     `json:"started_at"`
+	DurationMS int64         `json:"duration_ms"`
+	Request    *traceTimings `json:"request,omitempty"`
+}
+
+// captureSigning makes the signer log its canonical request and string to
+// sign into the bundle, in place of the SDK logger.
+func (b *debugBundle) captureSigning(o *v4.SignerOptions) {
+	o.LogSigning = true
+	o.Logger = logging.LoggerFunc(func(_ logging.Classification, format string, v ...interface{}) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.signing = fmt.Sprintf(format, v...)
+	})
+}
+
+func (b *debugBundle) setConfig(config resolvedConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.config = &config
+}
+
+// setRequest records a signed request, its URL and headers are copied as
+// they may still change before it is sent.
+func (b *debugBundle) setRequest(req *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u := *req.URL
+	b.request = &http.Request{Method: req.Method, URL: &u, Proto: req.Proto, Header: req.Header.Clone(), ContentLength: req.ContentLength}
+}
+
+func (b *debugBundle) setExchange(x harExchange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.exchange = &x
+}
+
+// write writes the zip to path, readable by the owner only like the HAR file.
+// Every part is scrubbed of the known secrets, the bundle is meant to be
+// attached to support tickets.
+func (b *debugBundle) write(path string, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	type bundleFile struct {
+		name string
+		data []byte
+	}
+	var files []bundleFile
+	add := func(name string, data []byte) {
+		files = append(files, bundleFile{name, data})
+	}
+	if b.config != nil {
+		data, err := json.MarshalIndent(b.config, "", "  ")
+		if err != nil {
+			return err
+		}
+		add("config.json", []byte(logger.scrub(string(data))))
+	}
+	if b.request != nil {
+		add("request.txt", []byte(debugRequest(b.request)))
+	}
+	if b.signing != "" {
+		add("canonical-request.txt", []byte(logger.scrub(b.signing)+"\n"))
+	}
+	timing := debugTiming{StartedAt: b.start.UTC(), DurationMS: now.Sub(b.start).Milliseconds()}
+	if b.exchange != nil {
+		data, err := json.MarshalIndent(newHAR(*b.exchange), "", "  ")
+		if err != nil {
+			return err
+		}
+		add("response.har", data)
+		timing.Request = &b.exchange.timings
+	}
+	data, err := json.MarshalIndent(timing, "", "  ")
+	if err != nil {
+		return err
+	}
+	add("timing.json", data)
+	add("log.txt", b.log.Bytes())
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(f)
+	for _, file := range files {
+		zf, err := w.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			_, err = zf.Write(file.data)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// debugRequest renders the request line and headers of a signed request,
+// the values of those carrying credentials redacted as in the HAR file.
+func debugRequest(req *http.Request) string {
+	proto := req.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s %s %s\n", req.Method, logger.scrub(req.URL.String()), proto)
+	for _, header := range harHeaders(req.Header) {
+		fmt.Fprintf(&s, "%s: %s\n", header.Name, header.Value)
+	}
+	return s.String()
+}
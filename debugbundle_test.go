@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readZip returns the files of a zip by name.
+func readZip(t *testing.T, path string) map[string]string {
+	r, err := zip.OpenReader(path)
+	if !assert.Nil(t, err, "should not be any error") {
+		return nil
+	}
+	defer r.Close()
+	files := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		assert.Nil(t, err, "should not be any error")
+		data, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	return files
+}
+
+func TestDebugBundle(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	bundle := newDebugBundle(start)
+	logger = newLogger(logFormatText, false, &bundle.log, &bundle.log)
+	logger.addSecret("SESSION")
+	logger.Error("request failed, token SESSION", nil)
+
+	bundle.setConfig(resolvedConfig{URL: "https://abcdef.lambda-url.eu-west-1.on.aws/", Method: "POST", CredentialSource: credentialSourceEnv})
+	req, _ := http.NewRequest(http.MethodPost, "https://abcdef.lambda-url.eu-west-1.on.aws/orders?page=2", strings.NewReader(`{"id":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	signer := newSigner("lambda", bundle.captureSigning)
+	assert.Nil(t, signer.SignHTTP(context.Background(), testCredentials, req, emptyPayloadHash, "lambda", "eu-west-1", start))
+	bundle.setRequest(req)
+	req.Header.Set("X-Changed", "after signing")
+	resp := &http.Response{StatusCode: http.StatusInternalServerError, Proto: "HTTP/1.1", Header: http.Header{"Content-Type": {"text/plain"}}}
+	bundle.setExchange(harExchange{start: start, req: req, resp: resp, responseBody: []byte("boom"), timings: traceTimings{TimeToFirstByte: 4, Total: 5}})
+
+	path := filepath.Join(t.TempDir(), "debug.zip")
+	assert.Nil(t, bundle.write(path, start.Add(1500*time.Millisecond)))
+	info, err := os.Stat(path)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	files := readZip(t, path)
+	for name, data := range files {
+		assert.NotContains(t, data, "SESSION", "%s should not hold the session token", name)
+		assert.NotContains(t, data, "SECRET", "%s should not hold the secret key", name)
+	}
+
+	var config resolvedConfig
+	assert.Nil(t, json.Unmarshal([]byte(files["config.json"]), &config))
+	assert.Equal(t, "POST", config.Method)
+
+	assert.True(t, strings.HasPrefix(files["request.txt"], "POST https://abcdef.lambda-url.eu-west-1.on.aws/orders?page=2 HTTP/1.1\n"), files["request.txt"])
+	assert.Contains(t, files["request.txt"], "Authorization: "+redacted+"\n")
+	assert.Contains(t, files["request.txt"], "X-Amz-Security-Token: "+redacted+"\n")
+	assert.NotContains(t, files["request.txt"], "X-Changed", "the request should be the one signed")
+
+	assert.Contains(t, files["canonical-request.txt"], "POST\n/orders\npage=2\n")
+	assert.Contains(t, files["canonical-request.txt"], "x-amz-security-token:"+redacted)
+	assert.Contains(t, files["canonical-request.txt"], "AWS4-HMAC-SHA256\n20240102T030405Z\n20240102/eu-west-1/lambda/aws4_request\n")
+
+	var har harFile
+	assert.Nil(t, json.Unmarshal([]byte(files["response.har"]), &har))
+	assert.Equal(t, 500, har.Log.Entries[0].Response.Status)
+	assert.Equal(t, "boom", har.Log.Entries[0].Response.Content.Text)
+
+	var timing debugTiming
+	assert.Nil(t, json.Unmarshal([]byte(files["timing.json"]), &timing))
+	assert.Equal(t, debugTiming{StartedAt: start, DurationMS: 1500, Request: &traceTimings{TimeToFirstByte: 4, Total: 5}}, timing)
+
+	assert.Equal(t, "request failed, token "+redacted+"\n", files["log.txt"])
+}
+
+func TestDebugBundleBeforeSending(t *testing.T) {
+	logger = newLogger(logFormatText, false, ioutil.Discard, ioutil.Discard)
+	bundle := newDebugBundle(time.Now())
+	path := filepath.Join(t.TempDir(), "debug.zip")
+	assert.Nil(t, bundle.write(path, time.Now()))
+
+	files := readZip(t, path)
+	assert.Contains(t, files, "timing.json")
+	assert.Contains(t, files, "log.txt")
+	for _, name := range []string{"config.json", "request.txt", "canonical-request.txt", "response.har"} {
+		assert.NotContains(t, files, name, "nothing was captured for %s", name)
+	}
+}
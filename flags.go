@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+// A single occurrence can also hold one value per line, which is how lists
+// are passed from the action inputs; blank lines are ignored.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			*l = append(*l, line)
+		}
+	}
+	return nil
+}
+
+// parseDurationFlag parses the value of a duration-like flag with
+// time.ParseDuration so every such flag accepts the same units (e.g. "500ms",
+// "30s", "2m"). An empty value means the flag is unset and yields zero.
+func parseDurationFlag(name, value string) (time.Duration, error) {
+	d, err := parseOffsetFlag(name, value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q for -%s, must not be negative", value, name)
+	}
+	return d, nil
+}
+
+// parseOffsetFlag is parseDurationFlag for flags accepting negative values,
+// such as an offset applied to a point in time.
+func parseOffsetFlag(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q for -%s, expected a value like 500ms, 30s or 2m", value, name)
+	}
+	return d, nil
+}
+
+// parseTimeFlag parses a point in time given as RFC 3339 (2006-01-02T15:04:05Z)
+// or in the basic format of X-Amz-Date (20060102T150405Z). An empty value
+// means the flag is unset and yields the zero time.
+func parseTimeFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, amzDateFormat} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q for -%s, expected a value like 2006-01-02T15:04:05Z or 20060102T150405Z", value, name)
+}
+
+// parseHTTPDateFlag is parseTimeFlag for flags sent as an HTTP header, it
+// also accepts an HTTP date (Mon, 02 Jan 2006 15:04:05 GMT) such as the
+// Last-Modified of a previous response.
+func parseHTTPDateFlag(name, value string) (time.Time, error) {
+	if t, err := http.ParseTime(value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := parseTimeFlag(name, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q for -%s, expected a value like Mon, 02 Jan 2006 15:04:05 GMT, 2006-01-02T15:04:05Z or 20060102T150405Z", value, name)
+	}
+	return t, nil
+}
+
+// parseMethodList parses the value of a comma-separated list of HTTP methods
+// such as POST,PUT. Methods are case-insensitive and returned upper-cased, an
+// empty list holds no method.
+func parseMethodList(name, list string) (map[string]bool, error) {
+	methods := map[string]bool{}
+	if strings.TrimSpace(list) == "" {
+		return methods, nil
+	}
+	for _, method := range strings.Split(list, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" || strings.IndexFunc(method, func(r rune) bool { return !isTokenChar(r) }) >= 0 {
+			return nil, fmt.Errorf("invalid method %q for -%s, expected a comma-separated list like POST,PUT,PATCH", method, name)
+		}
+		methods[method] = true
+	}
+	return methods, nil
+}
+
+// isTokenChar reports whether r may appear in an HTTP token such as a method,
+// once upper-cased.
+func isTokenChar(r rune) bool {
+	return 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
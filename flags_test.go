@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDurationFlag(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"500ms", 500 * time.Millisecond},
+		{"30s", 30 * time.Second},
+		{"1m30s", 90 * time.Second},
+	}
+
+	for _, test := range tests {
+		d, err := parseDurationFlag("timeout", test.value)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.expected, d, "unexpected duration")
+	}
+}
+
+func TestParseInvalidDurationFlag(t *testing.T) {
+	tests := []struct {
+		value         string
+		expectedError string
+	}{
+		{"5", `invalid duration "5" for -timeout, expected a value like 500ms, 30s or 2m`},
+		{"ten seconds", `invalid duration "ten seconds" for -timeout, expected a value like 500ms, 30s or 2m`},
+		{"5 s", `invalid duration "5 s" for -timeout, expected a value like 500ms, 30s or 2m`},
+		{"-1s", `invalid duration "-1s" for -timeout, must not be negative`},
+	}
+
+	for _, test := range tests {
+		d, err := parseDurationFlag("timeout", test.value)
+		assert.Zero(t, d)
+		assert.EqualError(t, err, test.expectedError)
+	}
+}
+
+func TestStringList(t *testing.T) {
+	var l stringList
+	assert.Nil(t, l.Set("a=1"))
+	assert.Nil(t, l.Set("\n  b=2\n\nc=3  \n"))
+	assert.Nil(t, l.Set(""))
+
+	assert.Equal(t, stringList{"a=1", "b=2", "c=3"}, l)
+	assert.Equal(t, "a=1,b=2,c=3", l.String())
+}
+
+func TestParseOffsetFlag(t *testing.T) {
+	d, err := parseOffsetFlag("clock-skew", "-90s")
+	assert.Nil(t, err, "negative offsets should be accepted")
+	assert.Equal(t, -90*time.Second, d)
+
+	_, err = parseOffsetFlag("clock-skew", "-90")
+	assert.EqualError(t, err, `invalid duration "-90" for -clock-skew, expected a value like 500ms, 30s or 2m`)
+}
+
+func TestParseTimeFlag(t *testing.T) {
+	expected := time.Date(2022, 7, 1, 12, 30, 0, 0, time.UTC)
+	for _, value := range []string{"2022-07-01T12:30:00Z", "2022-07-01T14:30:00+02:00", "20220701T123000Z"} {
+		parsed, err := parseTimeFlag("signing-time", value)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, expected, parsed, value)
+	}
+
+	parsed, err := parseTimeFlag("signing-time", "")
+	assert.Nil(t, err, "should not be any error")
+	assert.True(t, parsed.IsZero(), "an empty value should yield the zero time")
+
+	_, err = parseTimeFlag("signing-time", "yesterday")
+	assert.EqualError(t, err, `invalid time "yesterday" for -signing-time, expected a value like 2006-01-02T15:04:05Z or 20060102T150405Z`)
+}
+
+func TestParseHTTPDateFlag(t *testing.T) {
+	expected := time.Date(2022, 7, 1, 12, 30, 0, 0, time.UTC)
+	for _, value := range []string{"Fri, 01 Jul 2022 12:30:00 GMT", "2022-07-01T14:30:00+02:00", "20220701T123000Z"} {
+		parsed, err := parseHTTPDateFlag("if-unmodified-since", value)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, expected, parsed, value)
+	}
+
+	parsed, err := parseHTTPDateFlag("if-unmodified-since", "")
+	assert.Nil(t, err, "should not be any error")
+	assert.True(t, parsed.IsZero(), "an empty value should yield the zero time")
+
+	_, err = parseHTTPDateFlag("if-unmodified-since", "yesterday")
+	assert.EqualError(t, err, `invalid time "yesterday" for -if-unmodified-since, expected a value like Mon, 02 Jan 2006 15:04:05 GMT, 2006-01-02T15:04:05Z or 20060102T150405Z`)
+}
+
+func TestParseMethodList(t *testing.T) {
+	methods, err := parseMethodList("hash-body-methods", "post, PUT,Patch,M-SEARCH")
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, map[string]bool{"POST": true, "PUT": true, "PATCH": true, "M-SEARCH": true}, methods)
+
+	methods, err = parseMethodList("hash-body-methods", " ")
+	assert.Nil(t, err, "should not be any error")
+	assert.Empty(t, methods)
+
+	for _, list := range []string{"POST,", "PO ST", "GET;POST", "GET\tPOST"} {
+		_, err := parseMethodList("hash-body-methods", list)
+		assert.NotNil(t, err, "%q should be rejected", list)
+	}
+}
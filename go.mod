@@ -3,6 +3,10 @@ module github.com/nexthink-cloud/aws-sigv4-action
 go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.0.6
 	github.com/aws/aws-sdk-go-v2 v1.16.7
+	github.com/aws/smithy-go v1.12.0
+	github.com/itchyny/gojq v0.12.8
 	github.com/stretchr/testify v1.8.0
+	golang.org/x/text v0.3.8
 )
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// goldenContext is the number of unchanged lines around the changes of a
+// -golden-file diff, as with diff -u.
+const goldenContext = 3
+
+// maxDiffCells bounds the table of the line diff, a larger change between
+// two long bodies is shown as a whole rather than computed line by line.
+const maxDiffCells = 4 * 1024 * 1024
+
+// goldenFile is the expected response body of -golden-file.
+type goldenFile struct {
+	path string
+	want string
+	json bool
+}
+
+// loadGoldenFile reads the golden file up front so a wrong path fails before
+// the request is sent. With normalizeJSON the file must be a JSON document,
+// compared regardless of field order and whitespace.
+func loadGoldenFile(path string, normalizeJSON bool) (*goldenFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error trying to read -golden-file %s", err)
+	}
+	if normalizeJSON {
+		if data, err = normalizedJSON(data); err != nil {
+			return nil, fmt.Errorf("invalid -golden-file %s, not a JSON document: %s", path, err)
+		}
+	}
+	return &goldenFile{path: path, want: string(data), json: normalizeJSON}, nil
+}
+
+// compare returns the unified diff from the golden file to body, empty when
+// they match. A trailing newline is not significant, editors add one to the
+// files they save.
+func (g *goldenFile) compare(body []byte) string {
+	if g.json {
+		// A body that is not JSON is compared as is, the diff shows why
+		if normalized, err := normalizedJSON(body); err == nil {
+			body = normalized
+		}
+	}
+	want, got := strings.TrimSuffix(g.want, "\n"), strings.TrimSuffix(string(body), "\n")
+	if want == got {
+		return ""
+	}
+	return unifiedDiff(g.path, "response", strings.Split(want, "\n"), strings.Split(got, "\n"))
+}
+
+// normalizedJSON indents a JSON document with the keys of its objects in
+// sorted order. Numbers are kept as written.
+func normalizedJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the document")
+	}
+	return json.MarshalIndent(value, "", "  ")
+}
+
+// diffLine is a line of an edit script: kept (' '), removed ('-') or added
+// ('+'), with the number of lines of both sides before it.
+type diffLine struct {
+	op     byte
+	text   string
+	before [2]int
+}
+
+// unifiedDiff formats the changes from a to b as a unified diff.
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	lines := diffLines(a, b)
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		// A hunk extends while the next change is close enough to share context
+		start, end := i-goldenContext, i
+		for j := i; j < len(lines) && j <= end+2*goldenContext; j++ {
+			if lines[j].op != ' ' {
+				end = j
+			}
+		}
+		if start < 0 {
+			start = 0
+		}
+		stop := end + goldenContext + 1
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+		var count [2]int
+		for _, line := range lines[start:stop] {
+			if line.op != '+' {
+				count[0]++
+			}
+			if line.op != '-' {
+				count[1]++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(lines[start].before[0], count[0]), hunkRange(lines[start].before[1], count[1]))
+		for _, line := range lines[start:stop] {
+			fmt.Fprintf(&out, "%c%s\n", line.op, line.text)
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+// hunkRange is a range of a hunk header, which starts at the line before an
+// empty range.
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// diffLines returns the edit script from a to b of a longest common
+// subsequence of their lines, the common head and tail left out of it.
+func diffLines(a, b []string) []diffLine {
+	head := 0
+	for head < len(a) && head < len(b) && a[head] == b[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(a)-head && tail < len(b)-head && a[len(a)-1-tail] == b[len(b)-1-tail] {
+		tail++
+	}
+	ma, mb := a[head:len(a)-tail], b[head:len(b)-tail]
+
+	var lines []diffLine
+	var pos [2]int
+	add := func(op byte, text string) {
+		lines = append(lines, diffLine{op: op, text: text, before: pos})
+		if op != '+' {
+			pos[0]++
+		}
+		if op != '-' {
+			pos[1]++
+		}
+	}
+	for _, line := range a[:head] {
+		add(' ', line)
+	}
+	if (len(ma)+1)*(len(mb)+1) > maxDiffCells {
+		for _, line := range ma {
+			add('-', line)
+		}
+		for _, line := range mb {
+			add('+', line)
+		}
+	} else {
+		// lcs[i][j] is the length of a longest common subsequence of ma[i:] and mb[j:]
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				add(' ', ma[i])
+				i, j = i+1, j+1
+			case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+				add('-', ma[i])
+				i++
+			default:
+				add('+', mb[j])
+				j++
+			}
+		}
+	}
+	for _, line := range a[len(a)-tail:] {
+		add(' ', line)
+	}
+	return lines
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Split("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm", "\n")
+	b := strings.Split("a\nb\nC\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn", "\n")
+	assert.Equal(t, `--- golden.txt
++++ response
+@@ -1,6 +1,6 @@
+ a
+ b
+-c
++C
+ d
+ e
+ f
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+`, unifiedDiff("golden.txt", "response", a, b))
+
+	// Changes closer than twice the context share a hunk
+	a = strings.Split("1\n2\n3\n4\n5\n6\n7", "\n")
+	b = strings.Split("0\n1\n2\n3\n5\n6\n7", "\n")
+	assert.Equal(t, `--- a
++++ b
+@@ -1,7 +1,7 @@
++0
+ 1
+ 2
+ 3
+-4
+ 5
+ 6
+ 7
+`, unifiedDiff("a", "b", a, b))
+
+	assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+x\n", unifiedDiff("a", "b", nil, []string{"x"}))
+}
+
+func TestGoldenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("{\"id\": 1, \"tags\": [\"a\"]}\n"), 0600))
+
+	golden, err := loadGoldenFile(path, false)
+	assert.Nil(t, err, "should not be any error")
+	assert.Empty(t, golden.compare([]byte(`{"id": 1, "tags": ["a"]}`)), "a trailing newline should not count")
+	assert.Equal(t, "--- "+path+"\n+++ response\n@@ -1,1 +1,1 @@\n-{\"id\": 1, \"tags\": [\"a\"]}\n+{\"tags\": [\"a\"], \"id\": 1}\n", golden.compare([]byte(`{"tags": ["a"], "id": 1}`)))
+
+	golden, err = loadGoldenFile(path, true)
+	assert.Nil(t, err, "should not be any error")
+	assert.Empty(t, golden.compare([]byte(`{"tags":["a"],"id":1}`)), "field order and whitespace should not count")
+	assert.Equal(t, "--- "+path+"\n+++ response\n@@ -1,5 +1,5 @@\n {\n-  \"id\": 1,\n+  \"id\": 2,\n   \"tags\": [\n     \"a\"\n   ]\n", golden.compare([]byte(`{"tags":["a"],"id":2}`)))
+	assert.Contains(t, golden.compare([]byte("not json")), "+not json\n")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("plain"), 0600))
+	_, err = loadGoldenFile(path, true)
+	assert.Contains(t, err.Error(), "not a JSON document")
+	_, err = loadGoldenFile(filepath.Join(t.TempDir(), "missing"), false)
+	assert.Contains(t, err.Error(), "error trying to read -golden-file")
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// harFile is an HTTP Archive (HAR 1.2) as written by -har-file, it holds a
+// single entry: the signed request and its response.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harTimings are in milliseconds, -1 standing for a phase that did not happen
+// such as DNS and connect on a reused connection.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harExchange is what a HAR entry is built from. requestBody is nil when the
+// body was streamed from disk, responseBody when it went to -output-fd.
+type harExchange struct {
+	start        time.Time
+	req          *http.Request
+	requestBody  []byte
+	resp         *http.Response
+	responseBody []byte
+	timings      traceTimings
+}
+
+// newHAR builds the archive of an exchange. Headers carrying credentials are
+// redacted and the other values scrubbed of known secrets, the file is meant
+// to be attached to support tickets.
+func newHAR(x harExchange) harFile {
+	request := harRequest{
+		Method:      x.req.Method,
+		URL:         logger.scrub(x.req.URL.String()),
+		HTTPVersion: x.req.Proto,
+		Headers:     harHeaders(x.req.Header),
+		QueryString: []harNameValue{},
+		Cookies:     []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    x.req.ContentLength,
+	}
+	if x.requestBody != nil {
+		request.BodySize = int64(len(x.requestBody))
+		if len(x.requestBody) > 0 {
+			request.PostData = &harPostData{MimeType: x.req.Header.Get("Content-Type"), Text: logger.scrub(string(x.requestBody))}
+		}
+	}
+	for name, values := range x.req.URL.Query() {
+		for _, value := range values {
+			request.QueryString = append(request.QueryString, harNameValue{name, logger.scrub(value)})
+		}
+	}
+	sort.Slice(request.QueryString, func(i, j int) bool { return request.QueryString[i].Name < request.QueryString[j].Name })
+
+	content := harContent{Size: int64(len(x.responseBody)), MimeType: x.resp.Header.Get("Content-Type")}
+	if utf8.Valid(x.responseBody) {
+		content.Text = string(x.responseBody)
+	} else {
+		content.Text, content.Encoding = base64.StdEncoding.EncodeToString(x.responseBody), "base64"
+	}
+	response := harResponse{
+		Status:      x.resp.StatusCode,
+		StatusText:  http.StatusText(x.resp.StatusCode),
+		HTTPVersion: x.resp.Proto,
+		Headers:     harHeaders(x.resp.Header),
+		Cookies:     []harNameValue{},
+		Content:     content,
+		RedirectURL: x.resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    int64(len(x.responseBody)),
+	}
+
+	t := x.timings
+	timings := harTimings{Blocked: -1, DNS: harPhase(t.DNS), Connect: harPhase(t.Connect + t.TLSHandshake), SSL: harPhase(t.TLSHandshake)}
+	// Until the first byte is the time to send and wait, the rest is receiving
+	timings.Wait = t.TimeToFirstByte - t.DNS - t.Connect - t.TLSHandshake
+	if timings.Wait < 0 {
+		timings.Wait = 0
+	}
+	timings.Receive = t.Total - t.TimeToFirstByte
+	if timings.Receive < 0 {
+		timings.Receive = 0
+	}
+
+	return harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "aws-sigv4-action"},
+		Entries: []harEntry{{
+			StartedDateTime: x.start.UTC(),
+			Time:            t.Total,
+			Request:         request,
+			Response:        response,
+			Timings:         timings,
+		}},
+	}}
+}
+
+// harPhase maps an optional phase duration to its HAR value.
+func harPhase(ms float64) float64 {
+	if ms == 0 {
+		return -1
+	}
+	return ms
+}
+
+// harHeaders flattens headers in name order, see headerFields for redaction.
+func harHeaders(header http.Header) []harNameValue {
+	names := headerNames(header)
+
+	headers := []harNameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			if isSecretField(name) {
+				value = redacted
+			}
+			headers = append(headers, harNameValue{name, logger.scrub(value)})
+		}
+	}
+	return headers
+}
+
+// writeHAR writes the archive to path, readable by the owner only since the
+// bodies may carry sensitive data.
+func writeHAR(path string, har harFile) error {
+	data, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
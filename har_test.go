@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHAR(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://abcdef.lambda-url.eu-west-1.on.aws/orders?page=2&limit=10", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKID/20240102/eu-west-1/lambda/aws4_request")
+	req.Header.Set("X-Amz-Security-Token", "session")
+	resp := &http.Response{StatusCode: http.StatusCreated, Proto: "HTTP/1.1", Header: http.Header{"Content-Type": {"image/png"}}}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	har := newHAR(harExchange{
+		start:        start,
+		req:          req,
+		requestBody:  []byte(`{"id":1}`),
+		resp:         resp,
+		responseBody: []byte("\x89PNG\xff"),
+		timings:      traceTimings{DNS: 2, Connect: 3, TLSHandshake: 5, TimeToFirstByte: 30, Total: 40},
+	})
+
+	assert.Equal(t, "1.2", har.Log.Version)
+	entry := har.Log.Entries[0]
+	assert.Equal(t, start, entry.StartedDateTime)
+	assert.Equal(t, 40.0, entry.Time)
+	assert.Equal(t, []harNameValue{
+		{"Authorization", redacted},
+		{"Content-Type", "application/json"},
+		{"X-Amz-Security-Token", redacted},
+	}, entry.Request.Headers, "credentials should be redacted")
+	assert.Equal(t, []harNameValue{{"limit", "10"}, {"page", "2"}}, entry.Request.QueryString)
+	assert.Equal(t, &harPostData{MimeType: "application/json", Text: `{"id":1}`}, entry.Request.PostData)
+	assert.Equal(t, int64(8), entry.Request.BodySize)
+	assert.Equal(t, 201, entry.Response.Status)
+	assert.Equal(t, "Created", entry.Response.StatusText)
+	assert.Equal(t, harContent{Size: 5, MimeType: "image/png", Text: "iVBOR/8=", Encoding: "base64"}, entry.Response.Content, "binary bodies should be base64 encoded")
+	assert.Equal(t, harTimings{Blocked: -1, DNS: 2, Connect: 8, SSL: 5, Wait: 20, Receive: 10}, entry.Timings)
+
+	// Reused connection, no body
+	har = newHAR(harExchange{req: req, resp: resp, responseBody: []byte("ok"), timings: traceTimings{TimeToFirstByte: 4, Total: 5}})
+	entry = har.Log.Entries[0]
+	assert.Nil(t, entry.Request.PostData)
+	assert.Equal(t, harContent{Size: 2, MimeType: "image/png", Text: "ok"}, entry.Response.Content)
+	assert.Equal(t, harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: 4, Receive: 1}, entry.Timings)
+}
+
+func TestWriteHAR(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	path := filepath.Join(t.TempDir(), "request.har")
+	assert.Nil(t, writeHAR(path, newHAR(harExchange{req: req, resp: &http.Response{StatusCode: 200, Header: http.Header{}}})))
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err, "should not be any error")
+	var decoded map[string]map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "aws-sigv4-action", decoded["log"]["creator"].(map[string]interface{})["name"])
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// hookTimeout bounds the run of a -pre-request-hook.
+const hookTimeout = 30 * time.Second
+
+// hookRequest is the request handed to a -pre-request-hook on stdin and read
+// back from its stdout. Bodies that are not valid UTF-8 are base64 encoded,
+// body_encoding is then base64.
+type hookRequest struct {
+	Method       string            `json:"method"`
+	URL          string            `json:"url"`
+	Headers      map[string]string `json:"headers"`
+	Body         string            `json:"body"`
+	BodyEncoding string            `json:"body_encoding,omitempty"`
+}
+
+// hookedRequest is the part of a request a hook can change.
+type hookedRequest struct {
+	method string
+	url    string
+	header http.Header
+	body   string
+}
+
+// newHookRequest encodes a request about to be signed for the hook.
+func newHookRequest(r hookedRequest) hookRequest {
+	req := hookRequest{Method: r.method, URL: r.url, Headers: make(map[string]string, len(r.header)), Body: r.body}
+	for name, values := range r.header {
+		req.Headers[name] = strings.Join(values, ", ")
+	}
+	if !utf8.ValidString(r.body) {
+		req.Body, req.BodyEncoding = base64.StdEncoding.EncodeToString([]byte(r.body)), "base64"
+	}
+	return req
+}
+
+// parse validates the request returned by a hook.
+func (r hookRequest) parse() (hookedRequest, error) {
+	if r.Method == "" || strings.ContainsAny(r.Method, " \t\r\n") {
+		return hookedRequest{}, fmt.Errorf("invalid method %q", r.Method)
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return hookedRequest{}, fmt.Errorf("invalid url %q, expected an absolute http or https URL", r.URL)
+	}
+	hooked := hookedRequest{method: r.Method, url: r.URL, header: make(http.Header, len(r.Headers)), body: r.Body}
+	for name, value := range r.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return hookedRequest{}, fmt.Errorf("invalid header %q", name)
+		}
+		hooked.header.Set(name, value)
+	}
+	switch r.BodyEncoding {
+	case "":
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(r.Body)
+		if err != nil {
+			return hookedRequest{}, fmt.Errorf("invalid base64 body %s", err)
+		}
+		hooked.body = string(data)
+	default:
+		return hookedRequest{}, fmt.Errorf("invalid body_encoding %q, expected base64 or none", r.BodyEncoding)
+	}
+	return hooked, nil
+}
+
+// runPreRequestHook runs the hook executable with the request as JSON on
+// stdin and returns the request it writes as JSON on stdout, which is then
+// the one signed. The hook gets the environment of the run.
+func runPreRequestHook(path string, env map[string]string, req hookedRequest) (hookedRequest, error) {
+	input, err := json.Marshal(newHookRequest(req))
+	if err != nil {
+		return hookedRequest{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.Env = make([]string, 0, len(env))
+	for name, value := range env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	sort.Strings(cmd.Env)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", hookTimeout)
+		}
+		return hookedRequest{}, fmt.Errorf("pre-request hook %s failed: %s", path, strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+
+	var output hookRequest
+	decoder := json.NewDecoder(&stdout)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&output); err != nil {
+		return hookedRequest{}, fmt.Errorf("malformed output of pre-request hook %s, expected a JSON request like its input: %s", path, err)
+	}
+	hooked, err := output.parse()
+	if err != nil {
+		return hookedRequest{}, fmt.Errorf("malformed output of pre-request hook %s, %s", path, err)
+	}
+	return hooked, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeHook writes an executable shell script, hooks are skipped on Windows.
+func writeHook(t *testing.T, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script hooks need a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0700))
+	return path
+}
+
+func TestHookRequest(t *testing.T) {
+	req := newHookRequest(hookedRequest{"POST", "https://example.com/", http.Header{"Accept": {"a", "b"}}, "\xff\x00"})
+	assert.Equal(t, hookRequest{Method: "POST", URL: "https://example.com/", Headers: map[string]string{"Accept": "a, b"}, Body: "/wA=", BodyEncoding: "base64"}, req)
+
+	hooked, err := req.parse()
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, hookedRequest{"POST", "https://example.com/", http.Header{"Accept": {"a, b"}}, "\xff\x00"}, hooked)
+
+	for _, invalid := range []hookRequest{
+		{Method: "", URL: "https://example.com/"},
+		{Method: "GET", URL: "/relative"},
+		{Method: "GET", URL: "ftp://example.com/"},
+		{Method: "GET", URL: "https://example.com/", Headers: map[string]string{"Bad Name": "x"}},
+		{Method: "GET", URL: "https://example.com/", Headers: map[string]string{"X-Injected": "a\r\nHost: evil"}},
+		{Method: "GET", URL: "https://example.com/", Body: "!", BodyEncoding: "base64"},
+		{Method: "GET", URL: "https://example.com/", BodyEncoding: "gzip"},
+	} {
+		_, err := invalid.parse()
+		assert.NotNil(t, err, "%+v should be rejected", invalid)
+	}
+}
+
+func TestRunPreRequestHook(t *testing.T) {
+	hook := writeHook(t, `cat > /dev/null
+echo '{"method": "PUT", "url": "'"$HOOK_URL"'/hooked", "headers": {"X-Injected": "'"$HOOK_VALUE"'"}, "body": "from the hook"}'
+`)
+	identity := writeHook(t, "cat\n")
+
+	hooked, err := runPreRequestHook(identity, map[string]string{}, hookedRequest{"GET", "https://example.com/path", http.Header{"Accept": {"*/*"}}, "body"})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, hookedRequest{"GET", "https://example.com/path", http.Header{"Accept": {"*/*"}}, "body"}, hooked)
+
+	hooked, err = runPreRequestHook(hook, map[string]string{"HOOK_URL": "https://example.com", "HOOK_VALUE": "v1"}, hookedRequest{"GET", "https://example.com/", http.Header{}, ""})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, hookedRequest{"PUT", "https://example.com/hooked", http.Header{"X-Injected": {"v1"}}, "from the hook"}, hooked, "the hook should get the env of the run")
+
+	_, err = runPreRequestHook(writeHook(t, "echo 'not json'\n"), map[string]string{}, hookedRequest{"GET", "https://example.com/", http.Header{}, ""})
+	assert.Contains(t, err.Error(), "malformed output of pre-request hook")
+	_, err = runPreRequestHook(writeHook(t, `echo '{"method": "GET", "url": "https://example.com/", "extra": 1}'`+"\n"), map[string]string{}, hookedRequest{"GET", "https://example.com/", http.Header{}, ""})
+	assert.Contains(t, err.Error(), `unknown field "extra"`)
+	_, err = runPreRequestHook(writeHook(t, "echo 'no token' >&2\nexit 3\n"), map[string]string{}, hookedRequest{"GET", "https://example.com/", http.Header{}, ""})
+	assert.Contains(t, err.Error(), "failed: exit status 3 no token")
+}
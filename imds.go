@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// EnvIMDSEndpoint overrides the instance metadata endpoint, same as the SDKs
+	EnvIMDSEndpoint = "AWS_EC2_METADATA_SERVICE_ENDPOINT"
+
+	defaultIMDSEndpoint = "http://169.254.169.254"
+	imdsTimeout         = 2 * time.Second
+	imdsTokenTTL        = "60"
+)
+
+// newIMDSClient returns a client for the instance metadata service. It never
+// goes through a proxy and gives up quickly outside of EC2.
+func newIMDSClient() *http.Client {
+	return &http.Client{Timeout: imdsTimeout, Transport: &http.Transport{}}
+}
+
+// imdsRegion reads the region of the EC2 instance the runner is on from the
+// instance metadata service, using an IMDSv2 session token.
+func imdsRegion(ctx context.Context, client *http.Client, endpoint string) (string, error) {
+	if endpoint == "" {
+		endpoint = defaultIMDSEndpoint
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+
+	tokenReq, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	tokenReq.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", imdsTokenTTL)
+	token, err := imdsGet(client, tokenReq)
+	if err != nil {
+		return "", err
+	}
+
+	regionReq, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/latest/meta-data/placement/region", nil)
+	if err != nil {
+		return "", err
+	}
+	regionReq.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	region, err := imdsGet(client, regionReq)
+	if err == nil && region == "" {
+		err = fmt.Errorf("empty region in the instance metadata")
+	}
+	return region, err
+}
+
+func imdsGet(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected instance metadata response %s to %s", resp.Status, req.URL.Path)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
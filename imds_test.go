@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newIMDSServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			assert.Equal(t, imdsTokenTTL, r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds"))
+			w.Write([]byte("session-token"))
+		case r.Method == http.MethodGet && r.URL.Path == "/latest/meta-data/placement/region":
+			if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "session-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("eu-central-1\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestIMDSRegion(t *testing.T) {
+	server := newIMDSServer(t)
+	defer server.Close()
+
+	region, err := imdsRegion(context.Background(), newIMDSClient(), server.URL+"/")
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "eu-central-1", region)
+}
+
+func TestIMDSRegionUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := imdsRegion(context.Background(), newIMDSClient(), server.URL)
+	assert.EqualError(t, err, "unexpected instance metadata response 404 Not Found to /latest/api/token")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// invocationTypeHeader selects how Lambda runs the function, see
+// https://docs.aws.amazon.com/lambda/latest/api/API_Invoke.html
+const invocationTypeHeader = "X-Amz-Invocation-Type"
+
+// Invocation types of -invocation-type.
+const (
+	invocationRequestResponse = "RequestResponse"
+	invocationEvent           = "Event"
+	invocationDryRun          = "DryRun"
+)
+
+// invocationStatuses are the statuses Lambda answers a successful invocation
+// with: the response of the function, 202 Accepted once an event is queued
+// and 204 No Content once a dry run validated the request.
+var invocationStatuses = map[string]int{
+	invocationRequestResponse: http.StatusOK,
+	invocationEvent:           http.StatusAccepted,
+	invocationDryRun:          http.StatusNoContent,
+}
+
+// parseInvocationType returns the canonical name of the -invocation-type
+// value, whatever its case. An empty value selects none.
+func parseInvocationType(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	for invocationType := range invocationStatuses {
+		if strings.EqualFold(value, invocationType) {
+			return invocationType, nil
+		}
+	}
+	return "", fmt.Errorf("invalid -invocation-type %q, expected %s, %s or %s", value, invocationRequestResponse, invocationEvent, invocationDryRun)
+}
+
+// checkInvocationStatus warns when a successful status is not the one of the
+// invocation type, e.g. a 200 to an Event invocation means the endpoint ran
+// the function synchronously, ignoring the header. Errors are left to
+// -fail-on-error.
+func checkInvocationStatus(invocationType string, status int) {
+	expected := invocationStatuses[invocationType]
+	if status < 200 || status > 299 || status == expected {
+		return
+	}
+	logger.Warn(fmt.Sprintf("%s invocation answered %d instead of %d, the endpoint may not support %s", invocationType, status, expected, invocationTypeHeader), nil)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInvocationType(t *testing.T) {
+	for value, expected := range map[string]string{
+		"":                "",
+		"Event":           invocationEvent,
+		"event":           invocationEvent,
+		" DRYRUN ":        invocationDryRun,
+		"requestresponse": invocationRequestResponse,
+	} {
+		invocationType, err := parseInvocationType(value)
+		assert.Nil(t, err, "should not be any error for %q", value)
+		assert.Equal(t, expected, invocationType)
+	}
+
+	_, err := parseInvocationType("Async")
+	assert.EqualError(t, err, `invalid -invocation-type "Async", expected RequestResponse, Event or DryRun`)
+}
+
+func TestCheckInvocationStatus(t *testing.T) {
+	var stderr bytes.Buffer
+	defer func(l *actionLogger) { logger = l }(logger)
+	logger = newLogger(logFormatText, false, ioutil.Discard, &stderr)
+
+	checkInvocationStatus(invocationEvent, 202)
+	checkInvocationStatus(invocationDryRun, 204)
+	checkInvocationStatus(invocationEvent, 500)
+	assert.Empty(t, stderr.String(), "expected statuses and errors should not be reported")
+
+	checkInvocationStatus(invocationEvent, 200)
+	assert.Contains(t, stderr.String(), "Event invocation answered 200 instead of 202, the endpoint may not support X-Amz-Invocation-Type")
+}
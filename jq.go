@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// jqProgram is a compiled -jq program, run by gojq with the whole jq language
+// but the functions reading the environment or files (env, $ENV, input).
+type jqProgram struct {
+	code *gojq.Code
+}
+
+// parseJQ compiles a -jq program, a syntax error or an unknown function fails
+// the run up front.
+func parseJQ(source string) (*jqProgram, error) {
+	query, err := gojq.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -jq %q, %s", source, err)
+	}
+	// An empty environment, the run environment holds the credentials
+	code, err := gojq.Compile(query, gojq.WithEnvironLoader(func() []string { return nil }))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -jq %q, %s", source, err)
+	}
+	return &jqProgram{code: code}, nil
+}
+
+// transform runs the program over a JSON body. String outputs are written
+// raw, as with jq -r, the others as compact JSON, one output per line.
+func (p *jqProgram) transform(body []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// Numbers are kept exact, gojq handles big integers
+	decoder.UseNumber()
+	var input interface{}
+	if err := decoder.Decode(&input); err != nil {
+		return "", fmt.Errorf("the response is not valid JSON: %s", err)
+	}
+	if decoder.More() {
+		return "", fmt.Errorf("the response is not valid JSON: more than one value")
+	}
+
+	var lines []string
+	iter := p.code.Run(input)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := v.(error); ok {
+			return "", err
+		}
+		if s, ok := v.(string); ok {
+			lines = append(lines, s)
+			continue
+		}
+		data, err := gojq.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, string(data))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJQTransform(t *testing.T) {
+	body := []byte(`{
+		"token": "abc",
+		"count": 3,
+		"price": -1.50,
+		"id": 12345678901234567890,
+		"tags": ["a", "b", "c", "d"],
+		"items": [
+			{"id": 1, "name": "book", "stock": 0},
+			{"id": 2, "name": "pen", "stock": 12},
+			{"id": 3, "name": "ink", "stock": 4}
+		]
+	}`)
+	tests := []struct {
+		program, want string
+	}{
+		{".token", "abc"},
+		{".count, .price", "3\n-1.5"},
+		{".id", "12345678901234567890"},
+		{".missing", "null"},
+		{".tags[1:3]", `["b","c"]`},
+		{".items[].name", "book\npen\nink"},
+		{".items[] | select(.stock > 0) | {id, available: .stock}", "{\"available\":12,\"id\":2}\n{\"available\":4,\"id\":3}"},
+		{"reduce .items[] as $item (0; . + $item.stock)", "16"},
+		{".tags | join(\",\")", "a,b,c,d"},
+		{`[.items[] | select(.name | test("^p"))] | length`, "1"},
+		{".count * 2 - 1", "5"},
+		{"empty", ""},
+		{"env | length", "0"},
+	}
+	for _, test := range tests {
+		program, err := parseJQ(test.program)
+		if !assert.Nil(t, err, test.program) {
+			continue
+		}
+		got, err := program.transform(body)
+		assert.Nil(t, err, test.program)
+		assert.Equal(t, test.want, got, test.program)
+	}
+}
+
+func TestJQErrors(t *testing.T) {
+	for _, program := range []string{".items[", ".a | ", "{a: 1", "nosuchfunction(1)"} {
+		_, err := parseJQ(program)
+		if assert.NotNil(t, err, program) {
+			assert.Contains(t, err.Error(), "invalid -jq ", program)
+		}
+	}
+
+	p, err := parseJQ(".token[]")
+	assert.Nil(t, err, "should not be any error")
+	_, err = p.transform([]byte(`{"token": "abc"}`))
+	assert.EqualError(t, err, `cannot iterate over: string ("abc")`)
+
+	p, _ = parseJQ(".token")
+	_, err = p.transform([]byte("<html>"))
+	assert.Contains(t, err.Error(), "the response is not valid JSON")
+	_, err = p.transform([]byte(`{"token": 1} {"token": 2}`))
+	assert.Contains(t, err.Error(), "the response is not valid JSON")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	redacted = "[REDACTED]"
+)
+
+// logFields carries the structured context of a log line.
+type logFields map[string]interface{}
+
+// actionLogger writes human readable lines (info on stdout, warnings and
+// errors on stderr) or one JSON object per line on stderr. Secret values it has
+// been told about are scrubbed from every line whatever the format.
+type actionLogger struct {
+	mu      sync.Mutex
+	format  string
+	verbose bool
+	stdout  io.Writer
+	stderr  io.Writer
+	secrets []string
+	// deprecated holds the deprecated behaviors reported, for -strict
+	deprecated []string
+}
+
+var logger = newLogger(logFormatText, false, os.Stdout, os.Stderr)
+
+func newLogger(format string, verbose bool, stdout, stderr io.Writer) *actionLogger {
+	return &actionLogger{format: format, verbose: verbose, stdout: stdout, stderr: stderr}
+}
+
+// parseLogFormat validates the value of the -log-format flag.
+func parseLogFormat(format string) (string, error) {
+	switch format {
+	case "", logFormatText:
+		return logFormatText, nil
+	case logFormatJSON:
+		return logFormatJSON, nil
+	}
+	return "", fmt.Errorf("invalid log format %q, expected %s or %s", format, logFormatText, logFormatJSON)
+}
+
+// addSecret registers a value that must never appear in the logs.
+func (l *actionLogger) addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.secrets = append(l.secrets, secret)
+}
+
+func (l *actionLogger) Debug(msg string, fields logFields) {
+	if l.verbose {
+		l.log("debug", msg, fields)
+	}
+}
+
+func (l *actionLogger) Info(msg string, fields logFields) {
+	l.log("info", msg, fields)
+}
+
+func (l *actionLogger) Warn(msg string, fields logFields) {
+	l.log("warn", msg, fields)
+}
+
+// Deprecated warns about a deprecated behavior, one that will stop working
+// and that -strict turns into a failure.
+func (l *actionLogger) Deprecated(msg string, fields logFields) {
+	l.mu.Lock()
+	l.deprecated = append(l.deprecated, msg)
+	l.mu.Unlock()
+	l.log("warn", "deprecated: "+msg, fields)
+}
+
+// deprecations returns the deprecated behaviors reported so far.
+func (l *actionLogger) deprecations() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.deprecated...)
+}
+
+func (l *actionLogger) Error(msg string, fields logFields) {
+	l.log("error", msg, fields)
+}
+
+func (l *actionLogger) log(level, msg string, fields logFields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	msg = l.scrub(msg)
+	clean := make(logFields, len(fields))
+	for k, v := range fields {
+		if isSecretField(k) {
+			clean[k] = redacted
+		} else if s, ok := v.(string); ok {
+			clean[k] = l.scrub(s)
+		} else {
+			clean[k] = v
+		}
+	}
+
+	if l.format == logFormatJSON {
+		line := struct {
+			Level   string    `json:"level"`
+			Message string    `json:"message"`
+			Fields  logFields `json:"fields,omitempty"`
+		}{level, msg, clean}
+		data, err := json.Marshal(line)
+		if err != nil {
+			data, _ = json.Marshal(struct {
+				Level   string `json:"level"`
+				Message string `json:"message"`
+			}{level, msg})
+		}
+		fmt.Fprintln(l.stderr, l.scrub(string(data)))
+		return
+	}
+
+	w := l.stdout
+	if level == "warn" || level == "error" {
+		w = l.stderr
+	}
+	// Scrub the rendered line too, secrets can hide in nested values
+	fmt.Fprintln(w, l.scrub(msg+formatFields(clean)))
+}
+
+// scrub replaces every registered secret found in s.
+func (l *actionLogger) scrub(s string) string {
+	for _, secret := range l.secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+	return s
+}
+
+// formatFields renders fields as sorted key=value pairs for the text format.
+func formatFields(fields logFields) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	return b.String()
+}
+
+// isSecretField reports whether a field or header name is likely to carry a
+// credential.
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"secret", "token", "password", "authorization"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerFields flattens headers for logging, redacting the ones carrying
+// credentials such as Authorization and X-Amz-Security-Token.
+func headerFields(header http.Header) map[string]string {
+	fields := make(map[string]string, len(header))
+	for name, values := range header {
+		if isSecretField(name) {
+			fields[name] = redacted
+		} else {
+			fields[name] = strings.Join(values, ", ")
+		}
+	}
+	return fields
+}
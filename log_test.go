@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextLogger(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	l := newLogger(logFormatText, false, &stdout, &stderr)
+
+	l.Debug("hidden unless verbose", nil)
+	l.Info("guessing region", logFields{"url": "https://example.com", "attempt": 1})
+	l.Warn("ignore invalid header", nil)
+
+	assert.Equal(t, "guessing region attempt=1 url=https://example.com\n", stdout.String())
+	assert.Equal(t, "ignore invalid header\n", stderr.String())
+}
+
+func TestDeprecatedLogger(t *testing.T) {
+	var stderr bytes.Buffer
+	l := newLogger(logFormatText, false, &stderr, &stderr)
+
+	assert.Empty(t, l.deprecations())
+	l.Deprecated("header Authorization is reserved", nil)
+	l.Warn("not a deprecation", nil)
+	assert.Equal(t, []string{"header Authorization is reserved"}, l.deprecations())
+	assert.Equal(t, "deprecated: header Authorization is reserved\nnot a deprecation\n", stderr.String())
+}
+
+func TestJSONLogger(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	l := newLogger(logFormatJSON, true, &stdout, &stderr)
+
+	l.Debug("sending signed request", logFields{"method": "POST"})
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(stderr.Bytes(), &line), "log line should be valid JSON")
+	assert.Equal(t, "debug", line["level"])
+	assert.Equal(t, "sending signed request", line["message"])
+	assert.Equal(t, map[string]interface{}{"method": "POST"}, line["fields"])
+	assert.Empty(t, stdout.String(), "json logs should only go to stderr")
+}
+
+func TestLoggerNeverLogsSecrets(t *testing.T) {
+	for _, format := range []string{logFormatText, logFormatJSON} {
+		var stdout, stderr bytes.Buffer
+		l := newLogger(format, true, &stdout, &stderr)
+		l.addSecret("SECRET")
+		l.addSecret("SESSION")
+
+		header := http.Header{}
+		header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKID/19700101/eu-west-1/lambda/aws4_request")
+		header.Set("X-Amz-Security-Token", "SESSION")
+		header.Set("Content-Type", "application/json")
+		header.Set("X-Upstream", "Bearer SESSION")
+
+		l.Error("signing with SECRET failed", logFields{"secret_access_key": "SECRET", "note": "token SESSION", "headers": headerFields(header)})
+
+		out := stdout.String() + stderr.String()
+		assert.NotContains(t, out, "SECRET")
+		assert.NotContains(t, out, "SESSION")
+		assert.NotContains(t, out, "AKID/19700101")
+		assert.Contains(t, out, "application/json")
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	format, err := parseLogFormat("")
+	assert.Nil(t, err)
+	assert.Equal(t, logFormatText, format)
+
+	format, err = parseLogFormat("json")
+	assert.Nil(t, err)
+	assert.Equal(t, logFormatJSON, format)
+
+	_, err = parseLogFormat("xml")
+	assert.EqualError(t, err, `invalid log format "xml", expected text or json`)
+}
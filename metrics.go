@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestMetrics are the figures of a run written by -metrics-file, code is 0
+// when no response was received.
+type requestMetrics struct {
+	method   string
+	host     string
+	duration time.Duration
+	code     int
+	retries  int
+}
+
+// prometheus renders the metrics in the Prometheus text exposition format,
+// labelled with the method and host of the request.
+func (m requestMetrics) prometheus() string {
+	labels := fmt.Sprintf(`{method="%s",host="%s"}`, escapeLabelValue(m.method), escapeLabelValue(m.host))
+	var b strings.Builder
+	metric := func(name, kind, help, value string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s%s %s\n", name, help, name, kind, name, labels, value)
+	}
+	metric("request_duration_seconds", "gauge", "Duration of the signed request, retries included.", strconv.FormatFloat(m.duration.Seconds(), 'f', -1, 64))
+	metric("response_code", "gauge", "HTTP status code of the response, 0 when none was received.", strconv.Itoa(m.code))
+	metric("retries_total", "counter", "Number of times the request was retried.", strconv.Itoa(m.retries))
+	return b.String()
+}
+
+// escapeLabelValue escapes a label value as the text format requires.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// writeMetrics writes the metrics to path, replacing the metrics of a
+// previous run.
+func writeMetrics(path string, m requestMetrics) error {
+	return ioutil.WriteFile(path, []byte(m.prometheus()), 0644)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestMetricsPrometheus(t *testing.T) {
+	metrics := requestMetrics{method: "POST", host: `example.com:8080`, duration: 1250 * time.Millisecond, code: 503, retries: 2}
+	assert.Equal(t, `# HELP request_duration_seconds Duration of the signed request, retries included.
+# TYPE request_duration_seconds gauge
+request_duration_seconds{method="POST",host="example.com:8080"} 1.25
+# HELP response_code HTTP status code of the response, 0 when none was received.
+# TYPE response_code gauge
+response_code{method="POST",host="example.com:8080"} 503
+# HELP retries_total Number of times the request was retried.
+# TYPE retries_total counter
+retries_total{method="POST",host="example.com:8080"} 2
+`, metrics.prometheus())
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabelValue("a\"b\\c\nd"))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	notifyFormatJSON  = "json"
+	notifyFormatSlack = "slack"
+
+	notifyTimeout = 5 * time.Second
+)
+
+// notification is the summary posted to -notify-url once the request is done,
+// whether it succeeded or not.
+type notification struct {
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	Status     string `json:"status,omitempty"`
+	Code       int    `json:"code,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	RequestID  string `json:"request_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// text renders the notification as a single line for chat webhooks.
+func (n notification) text() string {
+	result := n.Status
+	if n.Error != "" {
+		result = "failed (" + n.Error + ")"
+		if n.Status != "" {
+			result = n.Status + ", " + result
+		}
+	}
+	line := fmt.Sprintf("%s %s: %s in %dms", n.Method, n.URL, result, n.DurationMS)
+	if n.RequestID != "" {
+		line += ", request ID " + n.RequestID
+	}
+	return line
+}
+
+// payload encodes the notification in the given format, slack being the
+// {"text": ...} message of Slack incoming webhooks (also understood by
+// Mattermost, Rocket.Chat...).
+func (n notification) payload(format string) ([]byte, error) {
+	switch format {
+	case "", notifyFormatJSON:
+		return json.Marshal(n)
+	case notifyFormatSlack:
+		return json.Marshal(struct {
+			Text string `json:"text"`
+		}{n.text()})
+	}
+	return nil, fmt.Errorf("invalid notify format %q, expected %s or %s", format, notifyFormatJSON, notifyFormatSlack)
+}
+
+// sendNotification posts the notification to the webhook. Callers treat a
+// failure as a warning, it never fails the run.
+func sendNotification(client *http.Client, url, format string, n notification) error {
+	payload, err := n.payload(format)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook answered %s", resp.Status)
+	}
+	return nil
+}
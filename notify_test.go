@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationPayload(t *testing.T) {
+	n := notification{Method: "POST", URL: "https://some-id.lambda-url.eu-west-1.on.aws/event", Status: "200 OK", Code: 200, DurationMS: 42, RequestID: "req-1"}
+
+	payload, err := n.payload(notifyFormatJSON)
+	assert.Nil(t, err, "should not be any error")
+	assert.JSONEq(t, `{"method": "POST", "url": "https://some-id.lambda-url.eu-west-1.on.aws/event", "status": "200 OK", "code": 200, "duration_ms": 42, "request_id": "req-1"}`, string(payload))
+
+	payload, err = n.payload(notifyFormatSlack)
+	assert.Nil(t, err, "should not be any error")
+	assert.JSONEq(t, `{"text": "POST https://some-id.lambda-url.eu-west-1.on.aws/event: 200 OK in 42ms, request ID req-1"}`, string(payload))
+
+	failed := notification{Method: "GET", URL: "https://unknown.invalid/", Error: errorDNS, DurationMS: 3}
+	assert.Equal(t, "GET https://unknown.invalid/: failed (dns) in 3ms", failed.text())
+
+	_, err = n.payload("xml")
+	assert.EqualError(t, err, `invalid notify format "xml", expected json or slack`)
+}
+
+func TestSendNotification(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	n := notification{Method: "GET", URL: "https://example.com/", Status: "200 OK", Code: 200}
+	assert.Nil(t, sendNotification(server.Client(), server.URL, notifyFormatJSON, n))
+	assert.Contains(t, received, `"status":"200 OK"`)
+	assert.EqualError(t, sendNotification(server.Client(), server.URL+"/broken", notifyFormatJSON, n), "notification webhook answered 500 Internal Server Error")
+}
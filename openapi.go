@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// openAPIExampleName is the key of the recorded example in the examples of
+// the operation, to be merged into a spec next to hand-written ones.
+const openAPIExampleName = "recorded"
+
+// openAPIDocument is the fragment of an OpenAPI 3 document written by
+// -openapi-example-file: the path and operation of the request, with the
+// exchange as examples of its parameters, request body and response.
+type openAPIDocument struct {
+	Paths map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+type openAPIOperation struct {
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name    string `json:"name"`
+	In      string `json:"in"`
+	Example string `json:"example"`
+}
+
+type openAPIBody struct {
+	Content map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Examples map[string]openAPIExample `json:"examples"`
+}
+
+// openAPIExample is an OpenAPI Example Object, Value is a JSON document for
+// JSON bodies and a string otherwise. Binary bodies have no value.
+type openAPIExample struct {
+	Summary     string      `json:"summary"`
+	Description string      `json:"description,omitempty"`
+	Value       interface{} `json:"value,omitempty"`
+}
+
+// openAPIIgnoredHeaders are request headers that are not parameters of the
+// API: the signature, the transport and those OpenAPI describes elsewhere.
+var openAPIIgnoredHeaders = map[string]bool{
+	"Accept":               true,
+	"Accept-Encoding":      true,
+	"Authorization":        true,
+	"Content-Length":       true,
+	"Content-Type":         true,
+	"Host":                 true,
+	"User-Agent":           true,
+	"X-Amz-Content-Sha256": true,
+	"X-Amz-Date":           true,
+	"X-Amz-Security-Token": true,
+}
+
+// newOpenAPIExample builds the document of an exchange, from the same capture
+// as -har-file. Values are scrubbed of known secrets, docs are published.
+func newOpenAPIExample(x harExchange) openAPIDocument {
+	var operation openAPIOperation
+	for _, name := range headerNames(x.req.Header) {
+		if openAPIIgnoredHeaders[name] || isSecretField(name) {
+			continue
+		}
+		operation.Parameters = append(operation.Parameters, openAPIParameter{Name: name, In: "header", Example: logger.scrub(strings.Join(x.req.Header[name], ", "))})
+	}
+	query := x.req.URL.Query()
+	queryNames := make([]string, 0, len(query))
+	for name := range query {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		operation.Parameters = append(operation.Parameters, openAPIParameter{Name: name, In: "query", Example: logger.scrub(strings.Join(query[name], ","))})
+	}
+
+	if len(x.requestBody) > 0 {
+		contentType := x.req.Header.Get("Content-Type")
+		operation.RequestBody = &openAPIBody{Content: openAPIContent(contentType, openAPIBodyExample("Signed request", contentType, x.requestBody))}
+	} else if x.requestBody == nil && x.req.ContentLength > 0 {
+		// Streamed from a file, it is not kept in memory
+		example := openAPIExample{Summary: "Signed request", Description: fmt.Sprintf("body of %d bytes streamed from a file, not included", x.req.ContentLength)}
+		operation.RequestBody = &openAPIBody{Content: openAPIContent(x.req.Header.Get("Content-Type"), example)}
+	}
+
+	response := openAPIResponse{Description: http.StatusText(x.resp.StatusCode)}
+	if response.Description == "" {
+		response.Description = x.resp.Status
+	}
+	if len(x.responseBody) > 0 {
+		contentType := x.resp.Header.Get("Content-Type")
+		response.Content = openAPIContent(contentType, openAPIBodyExample(x.resp.Status, contentType, x.responseBody))
+	}
+	operation.Responses = map[string]openAPIResponse{strconv.Itoa(x.resp.StatusCode): response}
+
+	path := x.req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	return openAPIDocument{Paths: map[string]map[string]openAPIOperation{
+		path: {strings.ToLower(x.req.Method): operation},
+	}}
+}
+
+// openAPIContent is the content map of a body, keyed by its media type
+// without parameters.
+func openAPIContent(contentType string, example openAPIExample) map[string]openAPIMediaType {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	return map[string]openAPIMediaType{mediaType: {Examples: map[string]openAPIExample{openAPIExampleName: example}}}
+}
+
+// openAPIBodyExample is the example of a body, a JSON document kept as is so
+// that the spec shows it structured.
+func openAPIBodyExample(summary, contentType string, body []byte) openAPIExample {
+	example := openAPIExample{Summary: summary}
+	if !utf8.Valid(body) {
+		example.Description = fmt.Sprintf("binary body of %d bytes, not included", len(body))
+		return example
+	}
+	text := logger.scrub(string(body))
+	if isJSONContentType(contentType) && json.Valid([]byte(text)) {
+		example.Value = json.RawMessage(text)
+	} else {
+		example.Value = text
+	}
+	return example
+}
+
+// writeOpenAPIExample writes the document to path, readable by the owner only
+// like the HAR file since the bodies may carry sensitive data.
+func writeOpenAPIExample(path string, document openAPIDocument) error {
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
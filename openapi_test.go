@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOpenAPIExample(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://abcdef.lambda-url.eu-west-1.on.aws/orders?page=2&limit=10", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKID/20240102/eu-west-1/lambda/aws4_request")
+	req.Header.Set("X-Amz-Date", "20240102T030405Z")
+	req.Header.Set("X-Amz-Invocation-Type", "RequestResponse")
+	resp := &http.Response{StatusCode: http.StatusCreated, Status: "201 Created", Header: http.Header{"Content-Type": {"application/json"}}}
+
+	document := newOpenAPIExample(harExchange{req: req, requestBody: []byte(`{"id":1}`), resp: resp, responseBody: []byte(`{"status":"created"}`)})
+	operation := document.Paths["/orders"]["post"]
+	assert.Equal(t, []openAPIParameter{
+		{Name: "X-Amz-Invocation-Type", In: "header", Example: "RequestResponse"},
+		{Name: "limit", In: "query", Example: "10"},
+		{Name: "page", In: "query", Example: "2"},
+	}, operation.Parameters, "signing headers should be left out")
+	assert.Equal(t, openAPIExample{Summary: "Signed request", Value: json.RawMessage(`{"id":1}`)}, operation.RequestBody.Content["application/json"].Examples[openAPIExampleName])
+	response := operation.Responses["201"]
+	assert.Equal(t, "Created", response.Description)
+	assert.Equal(t, openAPIExample{Summary: "201 Created", Value: json.RawMessage(`{"status":"created"}`)}, response.Content["application/json"].Examples[openAPIExampleName])
+
+	// Streamed request body, binary response
+	req, _ = http.NewRequest(http.MethodPut, "http://localhost", strings.NewReader("data"))
+	resp = &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{"Content-Type": {"image/png"}}}
+	document = newOpenAPIExample(harExchange{req: req, resp: resp, responseBody: []byte("\x89PNG\xff")})
+	operation = document.Paths["/"]["put"]
+	assert.Nil(t, operation.Parameters)
+	assert.Equal(t, "body of 4 bytes streamed from a file, not included", operation.RequestBody.Content["application/octet-stream"].Examples[openAPIExampleName].Description)
+	assert.Equal(t, openAPIExample{Summary: "200 OK", Description: "binary body of 5 bytes, not included"}, operation.Responses["200"].Content["image/png"].Examples[openAPIExampleName])
+
+	// No body at all, a text response
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost/health", nil)
+	resp = &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable", Header: http.Header{"Content-Type": {"text/plain"}}}
+	operation = newOpenAPIExample(harExchange{req: req, requestBody: []byte{}, resp: resp, responseBody: []byte("down")}).Paths["/health"]["get"]
+	assert.Nil(t, operation.RequestBody)
+	assert.Equal(t, "down", operation.Responses["503"].Content["text/plain"].Examples[openAPIExampleName].Value)
+}
+
+func TestWriteOpenAPIExample(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"application/json"}}}
+	path := filepath.Join(t.TempDir(), "example.json")
+	assert.Nil(t, writeOpenAPIExample(path, newOpenAPIExample(harExchange{req: req, resp: resp, responseBody: []byte(`{"ok":true}`)})))
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err, "should not be any error")
+	var decoded struct {
+		Paths map[string]map[string]struct {
+			Responses map[string]struct {
+				Content map[string]struct {
+					Examples map[string]struct {
+						Value map[string]bool `json:"value"`
+					} `json:"examples"`
+				} `json:"content"`
+			} `json:"responses"`
+		} `json:"paths"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, map[string]bool{"ok": true}, decoded.Paths["/"]["get"].Responses["200"].Content["application/json"].Examples["recorded"].Value, "JSON bodies should be written structured")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	EnvGitHubOutput      = "GITHUB_OUTPUT"
+	EnvGitHubStepSummary = "GITHUB_STEP_SUMMARY"
+)
+
+// actionOutput is a named value exposed to the following steps of the job.
+type actionOutput struct {
+	name  string
+	value string
+}
+
+// writeOutputs appends the outputs to the GITHUB_OUTPUT file. Runners
+// without the file only understand the deprecated ::set-output command,
+// which is written to w instead. It is also the fallback when the file
+// cannot be written (permissions, disk full), the outputs are not lost.
+func writeOutputs(path string, w io.Writer, outputs []actionOutput) {
+	if path == "" {
+		logger.Deprecated(fmt.Sprintf("%s env variable is not set, the outputs are written with the ::set-output command GitHub is removing", EnvGitHubOutput), nil)
+		writeSetOutput(w, outputs)
+		return
+	}
+	if err := appendOutputFile(path, outputs); err != nil {
+		logger.Warn(fmt.Sprintf("error writing the outputs to %s %s, falling back to ::set-output", EnvGitHubOutput, err), nil)
+		writeSetOutput(w, outputs)
+	}
+}
+
+// appendOutputFile writes every value with a random heredoc delimiter,
+// response bodies often span several lines.
+func appendOutputFile(path string, outputs []actionOutput) error {
+	var b strings.Builder
+	for _, o := range outputs {
+		delimiter, err := outputDelimiter()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s<<%s\n%s\n%s\n", o.name, delimiter, o.value, delimiter)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// setOutputEscaper escapes the values of ::set-output commands like the
+// actions toolkit, a raw newline would end the command.
+var setOutputEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+func writeSetOutput(w io.Writer, outputs []actionOutput) {
+	for _, o := range outputs {
+		fmt.Fprintf(w, "::set-output name=%s::%s\n", o.name, setOutputEscaper.Replace(o.value))
+	}
+}
+
+// defaultMaxOutputBytes keeps the message output well below the 1 MB GitHub
+// allows for all the outputs of a job.
+const defaultMaxOutputBytes = 512 * 1024
+
+// truncatedMarker ends a message output cut at -max-output-bytes.
+const truncatedMarker = "...[truncated]"
+
+// truncateOutput cuts value to at most max bytes marker included, on a UTF-8
+// character boundary, and reports whether it did. 0 disables the limit.
+func truncateOutput(value string, max int) (string, bool) {
+	if max <= 0 || len(value) <= max {
+		return value, false
+	}
+	cut := max - len(truncatedMarker)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	marked := value[:cut] + truncatedMarker
+	if len(marked) > max {
+		marked = marked[:max]
+	}
+	return marked, true
+}
+
+// outputDelimiter returns a heredoc delimiter no response body can contain
+// by chance.
+func outputDelimiter() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return "ghadelimiter_" + hex.EncodeToString(random), nil
+}
+
+// stepSummary is the at-a-glance result appended to the job summary: the
+// response, why the request failed, or the stats of a -repeat run.
+type stepSummary struct {
+	Method    string
+	URL       string
+	Status    string
+	Code      int
+	Duration  time.Duration
+	RequestID string
+	Error     string
+	Stats     *repeatStats
+}
+
+// requestID returns the AWS request ID of a response, the header name depends
+// on the service answering (Lambda, API Gateway, S3...).
+func requestID(header http.Header) string {
+	for _, name := range []string{"X-Amzn-Requestid", "X-Amz-Request-Id", "X-Amz-Apigw-Id"} {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// markdown renders the summary as a small markdown table.
+func (s stepSummary) markdown() string {
+	if s.Stats != nil {
+		return fmt.Sprintf("### %s %s\n\n| Requests | Success | Errors | p50 | p95 |\n| --- | --- | --- | --- | --- |\n| %d | %d | %d | %.1fms | %.1fms |\n\n",
+			s.Method, s.URL, s.Stats.Requests, s.Stats.Success, s.Stats.Errors, s.Stats.P50, s.Stats.P95)
+	}
+	requestID := s.RequestID
+	if requestID == "" {
+		requestID = "-"
+	}
+	status, code := s.Status, strconv.Itoa(s.Code)
+	if s.Error != "" {
+		status = "failed (" + s.Error + ")"
+		if s.Status != "" {
+			status = s.Status + ", " + status
+		}
+	}
+	if s.Code == 0 {
+		code = "-"
+	}
+	return fmt.Sprintf("### %s %s\n\n| Status | Code | Duration | Request ID |\n| --- | --- | --- | --- |\n| %s | %s | %s | `%s` |\n\n",
+		s.Method, s.URL, status, code, s.Duration.Round(time.Millisecond), requestID)
+}
+
+// writeStepSummary appends the summary to the file GitHub renders as the job
+// summary. Outside of GitHub Actions the env variable is absent and nothing is
+// written.
+func writeStepSummary(path string, s stepSummary) error {
+	if path == "" {
+		return fmt.Errorf("%s env variable is not set, job summary skipped", EnvGitHubStepSummary)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(s.markdown())
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteStepSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.md")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("previous step\n"), 0644))
+
+	s := stepSummary{
+		Method:    "POST",
+		URL:       "https://some-id.lambda-url.eu-west-1.on.aws/event",
+		Status:    "200 OK",
+		Code:      200,
+		Duration:  1234567 * time.Microsecond,
+		RequestID: "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+	}
+	assert.Nil(t, writeStepSummary(path, s))
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "previous step\n### POST https://some-id.lambda-url.eu-west-1.on.aws/event\n\n"+
+		"| Status | Code | Duration | Request ID |\n| --- | --- | --- | --- |\n"+
+		"| 200 OK | 200 | 1.235s | `c6af9ac6-7b61-11e6-9a41-93e8deadbeef` |\n\n", string(data))
+}
+
+func TestStepSummaryMarkdown(t *testing.T) {
+	failed := stepSummary{Method: "GET", URL: "https://example.com/", Duration: time.Second, Error: errorTimeout}
+	assert.Equal(t, "### GET https://example.com/\n\n| Status | Code | Duration | Request ID |\n| --- | --- | --- | --- |\n"+
+		"| failed (timeout) | - | 1s | `-` |\n\n", failed.markdown())
+
+	failed.Status, failed.Code, failed.Error = "200 OK", 200, errorHeaderMismatch
+	assert.Contains(t, failed.markdown(), "| 200 OK, failed (header_mismatch) | 200 | 1s |")
+
+	stats := stepSummary{Method: "GET", URL: "https://example.com/", Stats: &repeatStats{Requests: 10, Success: 9, Errors: 1, P50: 12.34, P95: 56.78}}
+	assert.Equal(t, "### GET https://example.com/\n\n| Requests | Success | Errors | p50 | p95 |\n| --- | --- | --- | --- | --- |\n"+
+		"| 10 | 9 | 1 | 12.3ms | 56.8ms |\n\n", stats.markdown())
+}
+
+func TestWriteStepSummaryWithoutEnv(t *testing.T) {
+	assert.EqualError(t, writeStepSummary("", stepSummary{}), "GITHUB_STEP_SUMMARY env variable is not set, job summary skipped")
+}
+
+func TestRequestID(t *testing.T) {
+	assert.Equal(t, "lambda-id", requestID(http.Header{"X-Amzn-Requestid": {"lambda-id"}}))
+	assert.Equal(t, "s3-id", requestID(http.Header{"X-Amz-Request-Id": {"s3-id"}}))
+	assert.Equal(t, "", requestID(http.Header{}))
+}
+
+func TestErrorBodyReachesOutputs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message":"missing field order_id"}`))
+	}))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	assert.Nil(t, err, "should not be any error")
+	defer resp.Body.Close()
+
+	message, err := readMessage(resp.Header.Get("Content-Type"), resp.Body)
+	assert.Nil(t, err, "should not be any error")
+
+	defer func(l *actionLogger) { logger = l }(logger)
+	logger = newLogger(logFormatText, false, ioutil.Discard, ioutil.Discard)
+	var out bytes.Buffer
+	writeOutputs("", &out, []actionOutput{
+		{"status", resp.Status},
+		{"code", strconv.Itoa(resp.StatusCode)},
+		{"message", string(message)},
+	})
+	assert.Equal(t, "::set-output name=status::400 Bad Request\n"+
+		"::set-output name=code::400\n"+
+		"::set-output name=message::{\"message\":\"missing field order_id\"}\n", out.String())
+	assert.Equal(t, []string{"GITHUB_OUTPUT env variable is not set, the outputs are written with the ::set-output command GitHub is removing"}, logger.deprecations())
+}
+
+func TestWriteOutputsFallback(t *testing.T) {
+	var stderr bytes.Buffer
+	defer func(l *actionLogger) { logger = l }(logger)
+	logger = newLogger(logFormatText, false, ioutil.Discard, &stderr)
+
+	// A directory cannot be appended to, whatever the permissions of the user
+	var out bytes.Buffer
+	writeOutputs(t.TempDir(), &out, []actionOutput{{"code", "200"}, {"message", "line 1\nline 2 at 100%"}})
+	assert.Equal(t, "::set-output name=code::200\n::set-output name=message::line 1%0Aline 2 at 100%25\n", out.String())
+	assert.Contains(t, stderr.String(), "error writing the outputs to GITHUB_OUTPUT")
+	assert.Contains(t, stderr.String(), "falling back to ::set-output")
+	assert.Empty(t, logger.deprecations(), "a failing file is not a deprecated setup")
+
+	out.Reset()
+	path := filepath.Join(t.TempDir(), "output")
+	writeOutputs(path, &out, []actionOutput{{"code", "200"}})
+	assert.Empty(t, out.String(), "a writable file should not fall back")
+	written, err := ioutil.ReadFile(path)
+	assert.Nil(t, err, "should not be any error")
+	assert.Regexp(t, `^code<<ghadelimiter_[0-9a-f]+\n200\n`, string(written))
+}
+
+func TestTruncateOutput(t *testing.T) {
+	tests := []struct {
+		value, want string
+		max         int
+		truncated   bool
+	}{
+		{"hello", "hello", 5, false},
+		{"hello", "hello", 0, false},
+		{"hello world, this is long", "hello worl...[truncated]", 24, true},
+		// The cut must not split the two bytes of an é
+		{"ééé" + strings.Repeat("x", 20), "é...[truncated]", 17, true},
+		{"hello world", "...[trunc", 9, true},
+	}
+	for _, test := range tests {
+		got, truncated := truncateOutput(test.value, test.max)
+		assert.Equal(t, test.want, got, test.value)
+		assert.Equal(t, test.truncated, truncated, test.value)
+	}
+}
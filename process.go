@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// credentialsProcessTimeout bounds the run of a -credentials-process.
+const credentialsProcessTimeout = time.Minute
+
+// credentialsProcessVersion is the only version of the credential_process
+// output format.
+const credentialsProcessVersion = 1
+
+// processCredentials is the JSON a credential_process writes on stdout, as
+// documented for the AWS CLI. Expiration is omitted for long-term keys.
+type processCredentials struct {
+	Version         int    `json:"Version"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+// credentialsProcess provides the credentials written by an external command
+// for -credentials-process, run again on every Retrieve so -refresh-credentials
+// picks up new ones. The command gets the environment of the run.
+type credentialsProcess struct {
+	args []string
+	env  map[string]string
+	now  func() time.Time
+}
+
+// newCredentialsProcess splits the command line like the AWS CLI does, the
+// action image has no shell to run it with.
+func newCredentialsProcess(command string, env map[string]string) (credentialsProcess, error) {
+	args, err := splitCommandLine(command)
+	if err != nil {
+		return credentialsProcess{}, fmt.Errorf("invalid -credentials-process %q, %s", command, err)
+	}
+	if len(args) == 0 {
+		return credentialsProcess{}, fmt.Errorf("invalid -credentials-process %q, expected a command", command)
+	}
+	return credentialsProcess{args: args, env: env, now: time.Now}, nil
+}
+
+func (p credentialsProcess) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, credentialsProcessTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.args[0], p.args[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.Env = make([]string, 0, len(p.env))
+	for name, value := range p.env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	sort.Strings(cmd.Env)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", credentialsProcessTimeout)
+		}
+		return aws.Credentials{}, fmt.Errorf("credentials process %s failed: %s", p.args[0], strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+
+	var output processCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return aws.Credentials{}, fmt.Errorf("malformed output of credentials process %s, expected credential_process JSON: %s", p.args[0], err)
+	}
+	credentials, err := output.credentials(p.now())
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("malformed output of credentials process %s, %s", p.args[0], err)
+	}
+	return credentials, nil
+}
+
+// credentials validates the output of the process. Credentials already
+// expired are refused, they could only get the request rejected.
+func (c processCredentials) credentials(now time.Time) (aws.Credentials, error) {
+	if c.Version != credentialsProcessVersion {
+		return aws.Credentials{}, fmt.Errorf("unsupported Version %d, expected %d", c.Version, credentialsProcessVersion)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return aws.Credentials{}, fmt.Errorf("AccessKeyId and SecretAccessKey are required")
+	}
+	credentials := aws.Credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		Source:          credentialSourceProcess,
+	}
+	if c.Expiration != "" {
+		expiration, err := time.Parse(time.RFC3339, c.Expiration)
+		if err != nil {
+			return aws.Credentials{}, fmt.Errorf("invalid Expiration %q, expected an RFC 3339 time", c.Expiration)
+		}
+		if !expiration.After(now) {
+			return aws.Credentials{}, fmt.Errorf("the credentials expired at %s", expiration.UTC().Format(time.RFC3339))
+		}
+		credentials.CanExpire, credentials.Expires = true, expiration
+	}
+	return credentials, nil
+}
+
+// splitCommandLine splits a command line into its arguments on unquoted
+// whitespace. Single quotes keep everything literally, double quotes and a
+// backslash outside of single quotes escape the next character.
+func splitCommandLine(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, escaped := false, false
+	var quote rune
+	for _, c := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+	}{
+		{"vault-creds --role ci", []string{"vault-creds", "--role", "ci"}},
+		{"  /opt/bin/creds\t-x  ", []string{"/opt/bin/creds", "-x"}},
+		{`creds --name 'my role' --path "a b\"c"`, []string{"creds", "--name", "my role", "--path", `a b"c`}},
+		{`creds '' dir\ name 'it\s'`, []string{"creds", "", "dir name", `it\s`}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		args, err := splitCommandLine(test.command)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.args, args, test.command)
+	}
+
+	for _, invalid := range []string{`creds "unterminated`, "creds 'open", `creds \`} {
+		_, err := splitCommandLine(invalid)
+		assert.EqualError(t, err, "unterminated quote or escape", invalid)
+	}
+}
+
+func TestProcessCredentials(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	credentials, err := processCredentials{Version: 1, AccessKeyID: "AKID", SecretAccessKey: "SECRET", SessionToken: "TOKEN", Expiration: "2024-01-02T04:04:05Z"}.credentials(now)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", SessionToken: "TOKEN", Source: credentialSourceProcess, CanExpire: true, Expires: now.Add(time.Hour)}, credentials)
+
+	credentials, err = processCredentials{Version: 1, AccessKeyID: "AKID", SecretAccessKey: "SECRET"}.credentials(now)
+	assert.Nil(t, err, "should not be any error")
+	assert.False(t, credentials.CanExpire, "long-term keys should not expire")
+
+	for _, test := range []struct {
+		output processCredentials
+		err    string
+	}{
+		{processCredentials{Version: 2, AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, "unsupported Version 2, expected 1"},
+		{processCredentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, "unsupported Version 0, expected 1"},
+		{processCredentials{Version: 1, AccessKeyID: "AKID"}, "AccessKeyId and SecretAccessKey are required"},
+		{processCredentials{Version: 1, AccessKeyID: "AKID", SecretAccessKey: "SECRET", Expiration: "tomorrow"}, `invalid Expiration "tomorrow", expected an RFC 3339 time`},
+		{processCredentials{Version: 1, AccessKeyID: "AKID", SecretAccessKey: "SECRET", Expiration: "2024-01-02T03:00:00+01:00"}, "the credentials expired at 2024-01-02T02:00:00Z"},
+	} {
+		_, err := test.output.credentials(now)
+		assert.EqualError(t, err, test.err)
+	}
+}
+
+func TestCredentialsProcess(t *testing.T) {
+	script := writeHook(t, `echo '{"Version": 1, "AccessKeyId": "'"$CREDS_KEY"'", "SecretAccessKey": "SECRET", "SessionToken": "TOKEN"}'`+"\n")
+	process, err := newCredentialsProcess(script+" --role ci", map[string]string{"CREDS_KEY": "AKIDPROCESS"})
+	assert.Nil(t, err, "should not be any error")
+	credentials, err := process.Retrieve(context.Background())
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "AKIDPROCESS", credentials.AccessKeyID, "the process should get the env of the run")
+	assert.Equal(t, "TOKEN", credentials.SessionToken)
+
+	process, _ = newCredentialsProcess(writeHook(t, "echo 'not json'\n"), nil)
+	_, err = process.Retrieve(context.Background())
+	assert.Contains(t, err.Error(), "malformed output of credentials process")
+
+	process, _ = newCredentialsProcess(writeHook(t, `echo '{"Version": 1}'`+"\n"), nil)
+	_, err = process.Retrieve(context.Background())
+	assert.Contains(t, err.Error(), "AccessKeyId and SecretAccessKey are required")
+
+	process, _ = newCredentialsProcess(writeHook(t, "echo denied >&2; exit 3\n"), nil)
+	_, err = process.Retrieve(context.Background())
+	assert.Contains(t, err.Error(), "exit status 3 denied")
+
+	_, err = newCredentialsProcess("  ", nil)
+	assert.EqualError(t, err, `invalid -credentials-process "  ", expected a command`)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const EnvAWSSharedCredentialsFile = "AWS_SHARED_CREDENTIALS_FILE"
+
+// profileCredentials are the credentials of a named profile of the shared
+// credentials file.
+type profileCredentials struct {
+	name        string
+	credentials aws.Credentials
+}
+
+// profileResult is the outcome of the request sent for a profile, Error is
+// set instead of Status and Code when no response came back.
+type profileResult struct {
+	Status     string `json:"status,omitempty"`
+	Code       int    `json:"code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// sharedCredentialsFile is the path of the shared credentials file, as the
+// AWS CLI and SDKs resolve it.
+func sharedCredentialsFile(env map[string]string) (string, error) {
+	if path := env[EnvAWSSharedCredentialsFile]; path != "" {
+		return path, nil
+	}
+	if home := env["HOME"]; home != "" {
+		return filepath.Join(home, ".aws", "credentials"), nil
+	}
+	return "", fmt.Errorf("%s or HOME env variable is required to find the profiles of -profiles", EnvAWSSharedCredentialsFile)
+}
+
+// parseProfileList parses the value of -profiles, a comma-separated list of
+// profile names given at most once each.
+func parseProfileList(list string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("invalid -profiles %q, expected a comma-separated list of profile names", list)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("invalid -profiles %q, profile %s is listed twice", list, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// loadProfiles reads the static credentials of the named profiles from the
+// INI shared credentials file, in the order given. A profile missing or
+// without an access key pair fails the whole run, before anything is sent.
+func loadProfiles(path string, names []string) ([]profileCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error trying to read the shared credentials file %s", err)
+	}
+	defer f.Close()
+
+	sections := map[string]map[string]string{}
+	var section map[string]string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";"):
+		case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+			name := strings.TrimSpace(text[1 : len(text)-1])
+			if sections[name] == nil {
+				sections[name] = map[string]string{}
+			}
+			section = sections[name]
+		default:
+			i := strings.Index(text, "=")
+			if i < 0 || section == nil {
+				return nil, fmt.Errorf("malformed shared credentials file %s at line %d, expected [profile] sections of key = value lines", path, line)
+			}
+			section[strings.ToLower(strings.TrimSpace(text[:i]))] = strings.TrimSpace(text[i+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error trying to read the shared credentials file %s", err)
+	}
+
+	profiles := make([]profileCredentials, 0, len(names))
+	for _, name := range names {
+		keys, ok := sections[name]
+		if !ok {
+			return nil, fmt.Errorf("profile %s not found in the shared credentials file %s", name, path)
+		}
+		credentials := aws.Credentials{
+			AccessKeyID:     keys["aws_access_key_id"],
+			SecretAccessKey: keys["aws_secret_access_key"],
+			SessionToken:    keys["aws_session_token"],
+			Source:          path,
+		}
+		if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+			return nil, fmt.Errorf("profile %s of the shared credentials file %s has no aws_access_key_id and aws_secret_access_key", name, path)
+		}
+		profiles = append(profiles, profileCredentials{name: name, credentials: credentials})
+	}
+	return profiles, nil
+}
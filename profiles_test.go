@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+const testCredentialsFile = `# CI accounts
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = SECRETDEFAULT
+
+[staging]
+aws_access_key_id=AKIDSTAGING
+aws_secret_access_key=SECRETSTAGING
+aws_session_token = TOKENSTAGING
+
+; no secret key
+[broken]
+aws_access_key_id = AKIDBROKEN
+`
+
+func TestParseProfileList(t *testing.T) {
+	names, err := parseProfileList(" staging,default ")
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, []string{"staging", "default"}, names)
+
+	_, err = parseProfileList("staging,,default")
+	assert.EqualError(t, err, `invalid -profiles "staging,,default", expected a comma-separated list of profile names`)
+	_, err = parseProfileList("staging,staging")
+	assert.EqualError(t, err, `invalid -profiles "staging,staging", profile staging is listed twice`)
+}
+
+func TestLoadProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(testCredentialsFile), 0600))
+
+	profiles, err := loadProfiles(path, []string{"staging", "default"})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, []profileCredentials{
+		{"staging", aws.Credentials{AccessKeyID: "AKIDSTAGING", SecretAccessKey: "SECRETSTAGING", SessionToken: "TOKENSTAGING", Source: path}},
+		{"default", aws.Credentials{AccessKeyID: "AKIDDEFAULT", SecretAccessKey: "SECRETDEFAULT", Source: path}},
+	}, profiles)
+
+	_, err = loadProfiles(path, []string{"default", "prod"})
+	assert.EqualError(t, err, "profile prod not found in the shared credentials file "+path)
+	_, err = loadProfiles(path, []string{"broken"})
+	assert.EqualError(t, err, "profile broken of the shared credentials file "+path+" has no aws_access_key_id and aws_secret_access_key")
+	_, err = loadProfiles(filepath.Join(t.TempDir(), "missing"), []string{"default"})
+	assert.Contains(t, err.Error(), "error trying to read the shared credentials file")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("aws_access_key_id = AKID\n"), 0600))
+	_, err = loadProfiles(path, []string{"default"})
+	assert.EqualError(t, err, "malformed shared credentials file "+path+" at line 1, expected [profile] sections of key = value lines")
+}
+
+func TestSharedCredentialsFile(t *testing.T) {
+	path, err := sharedCredentialsFile(map[string]string{EnvAWSSharedCredentialsFile: "/etc/aws/credentials", "HOME": "/home/runner"})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "/etc/aws/credentials", path)
+	path, _ = sharedCredentialsFile(map[string]string{"HOME": "/home/runner"})
+	assert.Equal(t, filepath.Join("/home/runner", ".aws", "credentials"), path)
+	_, err = sharedCredentialsFile(map[string]string{})
+	assert.NotNil(t, err, "should be an error without HOME")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// repeatStats aggregates the results of a -repeat run.
+type repeatStats struct {
+	Requests  int            `json:"requests"`
+	Success   int            `json:"success"`
+	Errors    int            `json:"errors"`
+	Statuses  map[string]int `json:"statuses"`
+	P50       float64        `json:"p50_ms"`
+	P95       float64        `json:"p95_ms"`
+	CacheHits int            `json:"cache_hits,omitempty"`
+}
+
+// repeatFlag is a single-request flag and whether it is in use.
+type repeatFlag struct {
+	name string
+	set  bool
+}
+
+// checkRepeatFlags rejects the flags a -repeat run cannot honor since it only
+// reports aggregate stats, rather than silently ignoring them.
+func checkRepeatFlags(flags []repeatFlag) error {
+	return checkModeFlags("repeat", flags)
+}
+
+// checkModeFlags rejects the single-request flags set along with the flag
+// of a multi-request mode, such as -repeat or -profiles.
+func checkModeFlags(mode string, flags []repeatFlag) error {
+	for _, f := range flags {
+		if f.set {
+			return fmt.Errorf("-%s is not supported with -%s", f.name, mode)
+		}
+	}
+	return nil
+}
+
+// repeatRequest calls send n times spread over the given number of workers.
+// send is expected to build, sign and fire a fresh request on every call
+// since a signature is only valid for a short period after X-Amz-Date.
+func repeatRequest(n, concurrency int, send func() (int, error)) repeatStats {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+
+	stats := repeatStats{Requests: n, Statuses: map[string]int{}}
+	latencies := make([]time.Duration, 0, n)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	jobs := make(chan struct{})
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				start := time.Now()
+				code, err := send()
+				elapsed := time.Since(start)
+
+				mu.Lock()
+				latencies = append(latencies, elapsed)
+				if err != nil {
+					stats.Errors++
+				} else {
+					stats.Statuses[strconv.Itoa(code)]++
+					if code >= 200 && code < 300 {
+						stats.Success++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	stats.P50 = percentile(latencies, 50)
+	stats.P95 = percentile(latencies, 95)
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies in
+// milliseconds.
+func percentile(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1]) / float64(time.Millisecond)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepeatRequest(t *testing.T) {
+	var calls int32
+	stats := repeatRequest(10, 3, func() (int, error) {
+		n := atomic.AddInt32(&calls, 1)
+		switch {
+		case n%5 == 0:
+			return 503, nil
+		case n == 1:
+			return 0, errors.New("connection reset")
+		}
+		return 200, nil
+	})
+
+	assert.Equal(t, int32(10), calls, "every request should be sent")
+	assert.Equal(t, 10, stats.Requests)
+	assert.Equal(t, 7, stats.Success)
+	assert.Equal(t, 1, stats.Errors)
+	assert.Equal(t, map[string]int{"200": 7, "503": 2}, stats.Statuses)
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 20; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, 10.0, percentile(latencies, 50))
+	assert.Equal(t, 19.0, percentile(latencies, 95))
+	assert.Equal(t, 0.0, percentile(nil, 95))
+}
+
+func TestCheckRepeatFlags(t *testing.T) {
+	assert.Nil(t, checkRepeatFlags([]repeatFlag{{"output-fd", false}, {"trace", false}}))
+	assert.EqualError(t, checkRepeatFlags([]repeatFlag{{"output-fd", false}, {"trace", true}, {"tls-info", true}}), "-trace is not supported with -repeat")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// requestSpec is a request kept as a fixture in HTTP message format: a
+// request line, header lines, a blank line, then the body.
+type requestSpec struct {
+	Method string
+	Target string
+	Header http.Header
+	Body   string
+}
+
+// readRequestSpec reads and parses the file of the -request-file flag.
+func readRequestSpec(path string) (requestSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return requestSpec{}, err
+	}
+	spec, err := parseRequestSpec(string(data))
+	if err != nil {
+		return requestSpec{}, fmt.Errorf("invalid request file %s: %s", path, err)
+	}
+	return spec, nil
+}
+
+// parseRequestSpec parses a request in HTTP message format. Lines may end
+// with CRLF or LF, the body is everything after the first blank line, as is.
+// Host and Content-Length are dropped: the authority comes from -lambda-url
+// and the length from the body actually sent.
+func parseRequestSpec(message string) (requestSpec, error) {
+	// Leading blank lines are tolerated, as in RFC 7230
+	message = strings.TrimLeft(message, "\r\n")
+	head, body := message, ""
+	i, n := strings.Index(message, "\n\n"), 2
+	if j := strings.Index(message, "\r\n\r\n"); j >= 0 && (i < 0 || j < i) {
+		i, n = j, 4
+	}
+	if i >= 0 {
+		head, body = message[:i], message[i+n:]
+	}
+
+	lines := strings.Split(strings.Replace(head, "\r\n", "\n", -1), "\n")
+	if strings.TrimSpace(lines[0]) == "" {
+		return requestSpec{}, fmt.Errorf("missing request line")
+	}
+
+	parts := strings.Fields(lines[0])
+	if len(parts) < 2 || len(parts) > 3 {
+		return requestSpec{}, fmt.Errorf("malformed request line %q, expected METHOD target [HTTP/1.1]", lines[0])
+	}
+	if len(parts) == 3 && !strings.HasPrefix(parts[2], "HTTP/") {
+		return requestSpec{}, fmt.Errorf("malformed request line %q, unknown protocol %s", lines[0], parts[2])
+	}
+	spec := requestSpec{Method: parts[0], Target: parts[1], Header: http.Header{}, Body: body}
+
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return requestSpec{}, fmt.Errorf("folded header line %q is not supported", line)
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return requestSpec{}, fmt.Errorf("malformed header line %q", line)
+		}
+		name := http.CanonicalHeaderKey(strings.TrimSpace(line[:i]))
+		if name == "Host" || name == "Content-Length" {
+			continue
+		}
+		spec.Header.Add(name, strings.TrimSpace(line[i+1:]))
+	}
+	return spec, nil
+}
+
+// resolve returns the URL of the request on the authority of base. An
+// absolute target (GET https://host/path) keeps only its path and query.
+func (s requestSpec) resolve(base string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	target, err := url.Parse(s.Target)
+	if err != nil {
+		return "", fmt.Errorf("invalid request target %q: %s", s.Target, err)
+	}
+	if target.Path == "" && target.Opaque == "" {
+		target.Path = "/"
+	}
+	resolved := *baseURL
+	resolved.Path, resolved.RawPath = target.Path, target.RawPath
+	resolved.RawQuery = target.RawQuery
+	resolved.Fragment = ""
+	return resolved.String(), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequestSpec(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected requestSpec
+	}{
+		{
+			"POST /orders?dry_run=true HTTP/1.1\nHost: example.com\nContent-Type: application/json\nContent-Length: 2\nX-Tag: a\nx-tag: b\n\n{\"id\": 1}\n\nsecond paragraph",
+			requestSpec{"POST", "/orders?dry_run=true", http.Header{"Content-Type": {"application/json"}, "X-Tag": {"a", "b"}}, "{\"id\": 1}\n\nsecond paragraph"},
+		},
+		{
+			"\r\nPUT /items/1 HTTP/1.1\r\nContent-Type: text/plain\r\n\r\nline 1\r\nline 2",
+			requestSpec{"PUT", "/items/1", http.Header{"Content-Type": {"text/plain"}}, "line 1\r\nline 2"},
+		},
+		{
+			"GET https://other.example.com/health",
+			requestSpec{"GET", "https://other.example.com/health", http.Header{}, ""},
+		},
+	}
+	for _, test := range tests {
+		spec, err := parseRequestSpec(test.message)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.expected, spec)
+	}
+}
+
+func TestParseInvalidRequestSpec(t *testing.T) {
+	tests := []struct {
+		message       string
+		expectedError string
+	}{
+		{"", "missing request line"},
+		{"GET\n", `malformed request line "GET", expected METHOD target [HTTP/1.1]`},
+		{"GET / SPDY/3\n", `malformed request line "GET / SPDY/3", unknown protocol SPDY/3`},
+		{"GET / HTTP/1.1\nno colon\n", `malformed header line "no colon"`},
+		{"GET / HTTP/1.1\nX-Long: a\n  b\n", `folded header line "  b" is not supported`},
+	}
+	for _, test := range tests {
+		_, err := parseRequestSpec(test.message)
+		assert.EqualError(t, err, test.expectedError, test.message)
+	}
+}
+
+func TestRequestSpecResolve(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"/orders?dry_run=true", "https://some-id.lambda-url.eu-west-1.on.aws/orders?dry_run=true"},
+		{"https://other.example.com/health", "https://some-id.lambda-url.eu-west-1.on.aws/health"},
+		{"/a%2Fb", "https://some-id.lambda-url.eu-west-1.on.aws/a%2Fb"},
+	}
+	for _, test := range tests {
+		resolved, err := requestSpec{Target: test.target}.resolve("https://some-id.lambda-url.eu-west-1.on.aws/ignored?q=1")
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.expected, resolved, test.target)
+	}
+}
+
+func TestReadRequestSpec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "request.http")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("DELETE\n"), 0600))
+	_, err := readRequestSpec(path)
+	assert.EqualError(t, err, "invalid request file "+path+`: malformed request line "DELETE", expected METHOD target [HTTP/1.1]`)
+}
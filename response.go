@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// decodeContentEncoding decompresses a body still carrying its
+// Content-Encoding, the transport never decodes it so that the body can be
+// counted as transferred, whether gzip was requested by wireTransport or by an
+// explicit Accept-Encoding. gzip, deflate and br (Brotli) are supported,
+// whatever their case. Encodings listed in order of application are undone in
+// reverse order. Empty bodies (HEAD, 204, 304) and unsupported encodings are
+// passed through as is.
+func decodeContentEncoding(encoding string, body io.Reader) (io.Reader, error) {
+	var encodings []string
+	for _, e := range strings.Split(encoding, ",") {
+		switch e = strings.ToLower(strings.TrimSpace(e)); e {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate", "br":
+			encodings = append(encodings, e)
+		default:
+			logger.Warn(fmt.Sprintf("unsupported response content encoding %s, body left as is", encoding), nil)
+			return body, nil
+		}
+	}
+	if len(encodings) == 0 {
+		return body, nil
+	}
+
+	buffered := bufio.NewReader(body)
+	if _, err := buffered.Peek(1); err == io.EOF {
+		return buffered, nil
+	}
+
+	var r io.Reader = buffered
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch encodings[i] {
+		case "deflate":
+			r, err = zlib.NewReader(r)
+		case "br":
+			// Brotli, served by some CDN edges
+			r = brotli.NewReader(r)
+		default:
+			r, err = gzip.NewReader(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// isTextContentType reports whether a media type holds text that can be
+// safely transcoded, binary content must be left untouched.
+func isTextContentType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// decodeCharset transcodes a text body to UTF-8 according to the charset
+// declared in its Content-Type. Bodies without charset, already in UTF-8 or
+// with a binary content type are returned as is.
+func decodeCharset(contentType string, body []byte) ([]byte, error) {
+	if contentType == "" {
+		return body, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !isTextContentType(mediaType) {
+		return body, nil
+	}
+
+	var enc encoding.Encoding
+	switch charset := strings.ToLower(params["charset"]); charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return body, nil
+	case "utf-16be", "utf-16":
+		// Big endian unless told otherwise by a byte order mark, which is stripped
+		enc = unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
+	case "utf-16le":
+		enc = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	default:
+		// Every charset label of the WHATWG encoding standard (Shift_JIS, GBK, KOI8-R...)
+		enc, err = htmlindex.Get(charset)
+		if err != nil {
+			return body, fmt.Errorf("unsupported response charset %s", charset)
+		}
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, fmt.Errorf("error decoding response charset %s", err)
+	}
+	return decoded, nil
+}
+
+// certificateInfo describes the certificate served by the endpoint, as reported by
+// -tls-info.
+type certificateInfo struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
+	DNSNames []string  `json:"dns_names,omitempty"`
+}
+
+// peerCertificateInfo returns the details of the leaf certificate of the
+// connection, or nil for plain HTTP responses.
+func peerCertificateInfo(state *tls.ConnectionState) *certificateInfo {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	return &certificateInfo{
+		Subject:  cert.Subject.String(),
+		Issuer:   cert.Issuer.String(),
+		NotAfter: cert.NotAfter.UTC(),
+		DNSNames: cert.DNSNames,
+	}
+}
+
+// isClockSkewError reports whether an error body says the request was refused
+// because the signing time is too far from the server clock.
+func isClockSkewError(body []byte) bool {
+	b := strings.ToLower(string(body))
+	for _, s := range []string{"signature expired", "requesttimetooskewed", "too far in the future", "signature not yet current"} {
+		if strings.Contains(b, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// readMessage reads the whole response body as UTF-8 whatever the status,
+// error bodies from Lambda and API Gateway carry the diagnostic. A charset it
+// cannot decode is reported and the body kept as is.
+func readMessage(contentType string, body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return data, err
+	}
+	decoded, err := decodeCharset(contentType, data)
+	if err != nil {
+		logger.Warn(err.Error(), nil)
+	}
+	return decoded, nil
+}
+
+// bodySink is a destination the response body is copied to as it is read,
+// along with the others. A write error is kept rather than returned so that
+// a failing sink does not cut the body short for the other ones.
+type bodySink struct {
+	name string
+	w    io.Writer
+	err  error
+}
+
+func (s *bodySink) Write(p []byte) (int, error) {
+	if s.err == nil {
+		_, s.err = s.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// copyToSinks reads body once, teeing it to every sink. The error returned
+// is the read error, the first sink that failed is reported apart.
+func copyToSinks(body io.Reader, sinks []*bodySink) (*bodySink, error) {
+	writers := make([]io.Writer, len(sinks))
+	for i, sink := range sinks {
+		writers[i] = sink
+	}
+	_, err := io.Copy(io.MultiWriter(writers...), body)
+	for _, sink := range sinks {
+		if sink.err != nil {
+			return sink, err
+		}
+	}
+	return nil, err
+}
+
+// countingReader counts the bytes read through it, the response body as it
+// was transferred: wireTransport leaves it compressed.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// headerExpectation is a response header that must be present for the call
+// to succeed, with an exact value or one matching a regular expression.
+type headerExpectation struct {
+	name    string
+	value   string
+	pattern *regexp.Regexp
+}
+
+// parseHeaderExpectation parses a value of -expect-header: name=value for an
+// exact match or name~=regexp for a pattern.
+func parseHeaderExpectation(expectation string) (headerExpectation, error) {
+	i := strings.Index(expectation, "=")
+	if i <= 0 || (i == 1 && expectation[0] == '~') {
+		return headerExpectation{}, fmt.Errorf("invalid header expectation %q, expected name=value or name~=regexp", expectation)
+	}
+	name, value := strings.TrimSpace(expectation[:i]), strings.TrimSpace(expectation[i+1:])
+	if !strings.HasSuffix(name, "~") {
+		return headerExpectation{name: name, value: value}, nil
+	}
+	pattern, err := regexp.Compile(value)
+	if err != nil {
+		return headerExpectation{}, fmt.Errorf("invalid header expectation %q, %s", expectation, err)
+	}
+	return headerExpectation{name: strings.TrimSpace(strings.TrimSuffix(name, "~")), pattern: pattern}, nil
+}
+
+// check returns an error describing the mismatch when no value of the header
+// meets the expectation.
+func (e headerExpectation) check(header http.Header) error {
+	values := header.Values(e.name)
+	for _, value := range values {
+		if (e.pattern != nil && e.pattern.MatchString(value)) || (e.pattern == nil && value == e.value) {
+			return nil
+		}
+	}
+	expected := fmt.Sprintf("%q", e.value)
+	if e.pattern != nil {
+		expected = fmt.Sprintf("matching %q", e.pattern.String())
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("response header %s is missing, expected %s", e.name, expected)
+	}
+	return fmt.Errorf("response header %s is %q, expected %s", e.name, strings.Join(values, ", "), expected)
+}
+
+// checkContentType returns an error describing the mismatch when contentType
+// does not match expected. A value ending with * is a prefix (application/*),
+// one with parameters must match exactly and otherwise the media type alone
+// is compared, application/json matching application/json; charset=utf-8.
+// Comparisons ignore case.
+func checkContentType(contentType, expected string) error {
+	actual := strings.ToLower(strings.TrimSpace(contentType))
+	want := strings.ToLower(strings.TrimSpace(expected))
+	var ok bool
+	switch {
+	case strings.HasSuffix(want, "*"):
+		ok = strings.HasPrefix(actual, strings.TrimSuffix(want, "*"))
+	case strings.Contains(want, ";"):
+		ok = actual == want
+	default:
+		mediaType, _, err := mime.ParseMediaType(actual)
+		ok = err == nil && mediaType == want
+	}
+	if ok {
+		return nil
+	}
+	if contentType == "" {
+		return fmt.Errorf("response Content-Type is missing, expected %q", expected)
+	}
+	return fmt.Errorf("response Content-Type is %q, expected %q", contentType, expected)
+}
+
+// isJSONContentType reports whether a Content-Type declares a JSON body.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// prettyJSON indents a JSON body for the logs, bodies that are not JSON nor
+// valid are returned as is.
+func prettyJSON(contentType string, body []byte) []byte {
+	if !isJSONContentType(contentType) {
+		return body
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, body, "", "  "); err != nil {
+		return body
+	}
+	return b.Bytes()
+}
+
+// isTerminal reports whether w is a terminal rather than a file or pipe, as
+// opposed to the logs of a CI runner.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCharset(t *testing.T) {
+	tests := []struct {
+		contentType  string
+		body         []byte
+		expectedBody string
+	}{
+		{"application/json", []byte(`{"city":"Zürich"}`), `{"city":"Zürich"}`},
+		{"application/json; charset=utf-8", []byte(`{"city":"Zürich"}`), `{"city":"Zürich"}`},
+		{"text/plain; charset=ISO-8859-1", []byte("Z\xfcrich"), "Zürich"},
+		{"text/html; charset=windows-1252", []byte("\x93caf\xe9\x94 \x80"), "“café” €"},
+		{"application/problem+json; charset=utf-16le", []byte("{\x00}\x00"), "{}"},
+		{"text/plain; charset=utf-16", []byte("\xfe\xff\x00o\x00k"), "ok"},
+		{"text/plain; charset=utf-16", []byte("\xff\xfeo\x00k\x00"), "ok"},
+		{"text/plain; charset=utf-16le", []byte("\xff\xfeo\x00k\x00"), "ok"},
+		{"text/plain; charset=utf-16be", []byte("\xfe\xff\x00o\x00k"), "ok"},
+		{"text/plain; charset=utf-16be", []byte("\x00o\x00k\x00"), "ok\ufffd"},
+		{"text/plain; charset=Shift_JIS", []byte("\x93\xfa\x96\x7b"), "日本"},
+		{"text/plain; charset=gbk", []byte("\xd6\xd0\xce\xc4"), "中文"},
+		{"text/plain; charset=koi8-r", []byte("\xf0\xd2\xc9\xd7\xc5\xd4"), "Привет"},
+	}
+
+	for _, test := range tests {
+		body, err := decodeCharset(test.contentType, test.body)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.expectedBody, string(body), "unexpected body")
+	}
+}
+
+func TestDecodeCharsetLeavesBinaryUntouched(t *testing.T) {
+	binary := []byte{0x89, 'P', 'N', 'G', 0xfc}
+	body, err := decodeCharset("image/png; charset=iso-8859-1", binary)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, binary, body)
+
+	body, err = decodeCharset("text/plain; charset=x-unknown", []byte("\xf0"))
+	assert.EqualError(t, err, "unsupported response charset x-unknown")
+	assert.Equal(t, []byte("\xf0"), body, "unsupported charsets should be left untouched")
+}
+
+func TestPeerCertificateInfo(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	assert.Nil(t, err, "should not be any error")
+	resp.Body.Close()
+
+	info := peerCertificateInfo(resp.TLS)
+	cert := server.Certificate()
+	assert.NotNil(t, info, "TLS details should be captured")
+	assert.Equal(t, "O=Acme Co", info.Subject)
+	assert.Equal(t, cert.Issuer.String(), info.Issuer)
+	assert.Equal(t, cert.NotAfter.UTC(), info.NotAfter)
+	assert.Equal(t, cert.DNSNames, info.DNSNames)
+
+	assert.Nil(t, peerCertificateInfo(nil), "plain HTTP has no certificate")
+}
+
+func TestDecodeContentEncoding(t *testing.T) {
+	var gzipped, deflated bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte(`{"ok":true}`))
+	gw.Close()
+	zw := zlib.NewWriter(&deflated)
+	zw.Write([]byte(`{"ok":true}`))
+	zw.Close()
+	var brotlied bytes.Buffer
+	bw := brotli.NewWriter(&brotlied)
+	bw.Write([]byte(`{"ok":true}`))
+	bw.Close()
+
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(`{"ok":true}`)},
+		{"identity", []byte(`{"ok":true}`)},
+		{"gzip", gzipped.Bytes()},
+		{"GZIP", gzipped.Bytes()},
+		{"deflate", deflated.Bytes()},
+		{"br", brotlied.Bytes()},
+		{"Br", brotlied.Bytes()},
+	}
+
+	for _, test := range tests {
+		r, err := decodeContentEncoding(test.encoding, bytes.NewReader(test.body))
+		assert.Nil(t, err, "should not be any error")
+		body, err := ioutil.ReadAll(r)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, `{"ok":true}`, string(body), "unexpected body")
+	}
+
+	var gzipDeflated bytes.Buffer
+	gw = gzip.NewWriter(&gzipDeflated)
+	gw.Write(deflated.Bytes())
+	gw.Close()
+	r, err := decodeContentEncoding("deflate, gzip", bytes.NewReader(gzipDeflated.Bytes()))
+	assert.Nil(t, err, "should not be any error")
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, `{"ok":true}`, string(body), "encodings should be undone in reverse order")
+}
+
+func TestDecodeContentEncodingPassThrough(t *testing.T) {
+	tests := []struct {
+		encoding string
+		body     string
+	}{
+		{"zstd", "zstandard bytes"},
+		{"gzip, zstd", "gzip then zstandard bytes"},
+		{"compress", "lzw bytes"},
+		// HEAD, 204 and 304 responses announce an encoding without any body
+		{"gzip", ""},
+		{"deflate", ""},
+	}
+
+	for _, test := range tests {
+		r, err := decodeContentEncoding(test.encoding, bytes.NewReader([]byte(test.body)))
+		assert.Nil(t, err, "should not be any error")
+		body, err := ioutil.ReadAll(r)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.body, string(body), "body should be left as is")
+	}
+}
+
+func TestDisableCompression(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte("plain"))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte("compressed"))
+		gw.Close()
+	}))
+	defer server.Close()
+
+	transport, err := newTransport(transportOptions{disableCompression: true})
+	assert.Nil(t, err, "should not be any error")
+	client := &http.Client{Transport: transport}
+
+	get := func(header http.Header) string {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		assert.Nil(t, err, "no error expected here")
+		req.Header = header
+		resp, err := client.Do(req)
+		assert.Nil(t, err, "should not be any error")
+		defer resp.Body.Close()
+		r, err := decodeContentEncoding(resp.Header.Get("Content-Encoding"), resp.Body)
+		assert.Nil(t, err, "should not be any error")
+		body, _ := ioutil.ReadAll(r)
+		return string(body)
+	}
+
+	assert.Equal(t, "plain", get(http.Header{}), "gzip should not be requested transparently")
+	assert.Equal(t, "compressed", get(http.Header{"Accept-Encoding": []string{"gzip"}}), "explicitly requested gzip should be decoded")
+}
+
+func TestIsClockSkewError(t *testing.T) {
+	assert.True(t, isClockSkewError([]byte(`{"message":"Signature expired: 20220101T000000Z is now earlier than 20220101T000500Z (20220101T001000Z - 5 min.)"}`)))
+	assert.True(t, isClockSkewError([]byte(`<Error><Code>RequestTimeTooSkewed</Code></Error>`)))
+	assert.True(t, isClockSkewError([]byte(`{"message":"Signature not yet current: 20220101T001000Z is still later than 20220101T000500Z"}`)))
+	assert.False(t, isClockSkewError([]byte(`{"message":"Forbidden"}`)))
+}
+
+func TestHeaderExpectation(t *testing.T) {
+	header := http.Header{"X-Status": {"ok"}, "X-Version": {"v1.2.3"}}
+
+	exact, err := parseHeaderExpectation("X-Status=ok")
+	assert.Nil(t, err, "should not be any error")
+	assert.Nil(t, exact.check(header))
+	assert.EqualError(t, exact.check(http.Header{"X-Status": {"degraded"}}), `response header X-Status is "degraded", expected "ok"`)
+	assert.EqualError(t, exact.check(http.Header{}), `response header X-Status is missing, expected "ok"`)
+
+	pattern, err := parseHeaderExpectation(`x-version~=^v1\.\d+`)
+	assert.Nil(t, err, "should not be any error")
+	assert.Nil(t, pattern.check(header), "header names should be case insensitive")
+	assert.EqualError(t, pattern.check(http.Header{"X-Version": {"v2.0.0"}}), `response header x-version is "v2.0.0", expected matching "^v1\\.\\d+"`)
+
+	empty, err := parseHeaderExpectation("X-Empty=")
+	assert.Nil(t, err, "should not be any error")
+	assert.Nil(t, empty.check(http.Header{"X-Empty": {""}}))
+
+	for _, e := range []string{"X-Status", "=ok", "~=ok", "X-Version~=(v1"} {
+		_, err := parseHeaderExpectation(e)
+		assert.NotNil(t, err, "%q should be rejected", e)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	assert.Equal(t, "{\n  \"id\": 1,\n  \"tags\": [\n    \"a\"\n  ]\n}", string(prettyJSON("application/json; charset=utf-8", []byte(`{"id":1,"tags":["a"]}`))))
+	assert.Equal(t, "{\n  \"title\": \"Not Found\"\n}", string(prettyJSON("application/problem+json", []byte(`{"title":"Not Found"}`))))
+	assert.Equal(t, `{"id":1}`, string(prettyJSON("text/plain", []byte(`{"id":1}`))), "only JSON content types should be indented")
+	assert.Equal(t, `{"id":`, string(prettyJSON("application/json", []byte(`{"id":`))), "invalid JSON should be left as is")
+
+	var b bytes.Buffer
+	assert.False(t, isTerminal(&b))
+}
+
+func TestCheckContentType(t *testing.T) {
+	tests := []struct {
+		contentType, expected string
+		ok                    bool
+	}{
+		{"application/json", "application/json", true},
+		{"application/json; charset=utf-8", "application/json", true},
+		{"Application/JSON", "application/json", true},
+		{"application/problem+json", "application/json", false},
+		{"application/json; charset=utf-8", "application/json; charset=utf-8", true},
+		{"application/json; charset=iso-8859-1", "application/json; charset=utf-8", false},
+		{"text/html; charset=utf-8", "text/*", true},
+		{"application/json", "text/*", false},
+		{"", "application/json", false},
+	}
+	for _, test := range tests {
+		err := checkContentType(test.contentType, test.expected)
+		assert.Equal(t, test.ok, err == nil, test.contentType+" against "+test.expected)
+	}
+	assert.EqualError(t, checkContentType("text/html", "application/json"), `response Content-Type is "text/html", expected "application/json"`)
+	assert.EqualError(t, checkContentType("", "application/json"), `response Content-Type is missing, expected "application/json"`)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestCopyToSinks(t *testing.T) {
+	var first, last bytes.Buffer
+	failed, err := copyToSinks(strings.NewReader("payload"), []*bodySink{{name: "first", w: &first}, {name: "last", w: &last}})
+	assert.Nil(t, err, "should not be any error")
+	assert.Nil(t, failed)
+	assert.Equal(t, "payload", first.String())
+	assert.Equal(t, "payload", last.String())
+
+	last.Reset()
+	failed, err = copyToSinks(strings.NewReader("payload"), []*bodySink{{name: "broken", w: failingWriter{}}, {name: "last", w: &last}})
+	assert.Nil(t, err, "a sink failure should not be a read error")
+	assert.Equal(t, "broken", failed.name)
+	assert.EqualError(t, failed.err, "disk full")
+	assert.Equal(t, "payload", last.String(), "the other sinks should get the whole body")
+}
+
+func TestCountingReader(t *testing.T) {
+	r := &countingReader{r: strings.NewReader("payload")}
+	data, err := ioutil.ReadAll(r)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "payload", string(data))
+	assert.Equal(t, int64(7), r.n)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRetryOnStatus are the statuses retried unless -retry-on-status says
+// otherwise: throttling and server errors.
+const defaultRetryOnStatus = "429,500-599"
+
+// statusRange is an inclusive range of HTTP status codes, a single code is a
+// range of one.
+type statusRange struct {
+	min, max int
+}
+
+// statusSet is a list of status codes and ranges such as 408,429,500-504.
+type statusSet []statusRange
+
+// parseStatusList parses the value of a status list flag, the codes must be
+// valid HTTP statuses (100 to 599) and every range in increasing order. An
+// empty list matches no status.
+func parseStatusList(name, list string) (statusSet, error) {
+	var set statusSet
+	if strings.TrimSpace(list) == "" {
+		return set, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		bounds := strings.SplitN(item, "-", 2)
+		min, err := parseStatusCode(name, item, bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		max := min
+		if len(bounds) == 2 {
+			if max, err = parseStatusCode(name, item, bounds[1]); err != nil {
+				return nil, err
+			}
+			if max < min {
+				return nil, fmt.Errorf("invalid status range %q for -%s, %d is greater than %d", item, name, min, max)
+			}
+		}
+		set = append(set, statusRange{min, max})
+	}
+	return set, nil
+}
+
+func parseStatusCode(name, item, code string) (int, error) {
+	status, err := strconv.Atoi(strings.TrimSpace(code))
+	if err != nil || status < 100 || status > 599 {
+		return 0, fmt.Errorf("invalid status %q for -%s, expected codes between 100 and 599 or ranges like 500-504", item, name)
+	}
+	return status, nil
+}
+
+// contains reports whether code is in one of the ranges of the set.
+func (s statusSet) contains(code int) bool {
+	for _, r := range s {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// Reasons a retry loop ended without a successful attempt, reported by the
+// retry_stop output.
+const (
+	retryStopRetries  = "retries"
+	retryStopDeadline = "deadline"
+)
+
+// maxRetryAfter caps the wait asked by a Retry-After header, a server
+// asking for hours would otherwise hold the job until it times out.
+const maxRetryAfter = 2 * time.Minute
+
+// parseRetryAfter returns the wait asked by a Retry-After header, either a
+// number of seconds or an HTTP date, relative to now. A date in the past
+// asks for no wait.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// retryPolicy sends a request again after a connection error or one of the
+// retried statuses, waiting backoff before the first retry and twice as long
+// before each of the next ones. A response with a Retry-After header sets the
+// wait before the next retry instead, up to maxRetryAfter.
+type retryPolicy struct {
+	retries  int
+	backoff  time.Duration
+	statuses statusSet
+	sleep    func(time.Duration)
+}
+
+// retryResult is how a retry loop went: the number of attempts, the failure
+// of the last attempt that failed (an error kind or a status) and, when the
+// last attempt still called for a retry, what stopped the loop.
+type retryResult struct {
+	attempts  int
+	lastError string
+	stop      string
+}
+
+// do calls send until it gets a response whose status is not retried, the
+// retries are exhausted or the deadline of ctx is too close for the next
+// backoff, returning the last response or error. send must sign a fresh
+// request bound to ctx on every call, a signature is only valid for a short
+// period after X-Amz-Date.
+func (p retryPolicy) do(ctx context.Context, send func(context.Context) (*http.Response, error)) (*http.Response, retryResult, error) {
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	backoff := p.backoff
+	var result retryResult
+	for attempt := 1; ; attempt++ {
+		resp, err := send(ctx)
+		result.attempts = attempt
+		if err == nil && !p.statuses.contains(resp.StatusCode) {
+			return resp, result, nil
+		}
+		if err != nil {
+			result.lastError, _ = classifyRequestError(err)
+		} else {
+			result.lastError = resp.Status
+		}
+		wait := backoff
+		if err == nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				wait = retryAfter
+				if wait > maxRetryAfter {
+					wait = maxRetryAfter
+				}
+			}
+		}
+		deadline, hasDeadline := ctx.Deadline()
+		switch {
+		case ctx.Err() != nil:
+			result.stop = retryStopDeadline
+		case attempt > p.retries:
+			result.stop = retryStopRetries
+		case hasDeadline && time.Until(deadline) < wait:
+			// Waiting would exhaust the budget before the next attempt starts
+			result.stop = retryStopDeadline
+		}
+		if result.stop != "" {
+			return resp, result, err
+		}
+
+		fields := logFields{"attempt": attempt, "backoff_ms": wait.Milliseconds(), "error": result.lastError}
+		if err == nil {
+			// Drain the body so the connection goes back to the pool
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		logger.Warn("retrying the request", fields)
+		sleep(wait)
+		backoff *= 2
+	}
+}
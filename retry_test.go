@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatusList(t *testing.T) {
+	set, err := parseStatusList("retry-on-status", "408, 429,500-504")
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, statusSet{{408, 408}, {429, 429}, {500, 504}}, set)
+	for code, retried := range map[int]bool{408: true, 429: true, 500: true, 502: true, 504: true, 404: false, 503: true, 505: false, 200: false} {
+		assert.Equal(t, retried, set.contains(code), "status %d", code)
+	}
+
+	set, err = parseStatusList("retry-on-status", "")
+	assert.Nil(t, err, "should not be any error")
+	assert.False(t, set.contains(503), "an empty list should match no status")
+
+	_, err = parseStatusList("retry-on-status", "504-500")
+	assert.EqualError(t, err, `invalid status range "504-500" for -retry-on-status, 504 is greater than 500`)
+	for _, list := range []string{"abc", "429,", "99", "600", "500-", "-500", "500-600", "5xx"} {
+		_, err = parseStatusList("retry-on-status", list)
+		assert.NotNil(t, err, "%q should be rejected", list)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var sleeps []time.Duration
+	codes := []int{503, 429, 200}
+	var calls int
+	policy := retryPolicy{retries: 3, backoff: time.Second, statuses: statusSet{{429, 429}, {500, 599}}, sleep: func(d time.Duration) { sleeps = append(sleeps, d) }}
+	resp, result, err := policy.do(context.Background(), func(context.Context) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("connection reset")
+		}
+		code := codes[calls-2]
+		return &http.Response{StatusCode: code, Status: http.StatusText(code), Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, retryResult{attempts: 4, lastError: "Too Many Requests"}, result)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, sleeps, "the backoff should double")
+
+	// The last response is returned once the retries are exhausted
+	calls = 0
+	policy = retryPolicy{retries: 1, statuses: statusSet{{500, 599}}, sleep: func(time.Duration) {}}
+	resp, result, err = policy.do(context.Background(), func(context.Context) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 502, Status: "502", Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, 502, resp.StatusCode)
+	assert.Equal(t, retryResult{attempts: 2, lastError: "502", stop: retryStopRetries}, result)
+
+	// A status outside the list is not retried
+	calls = 0
+	resp, result, _ = policy.do(context.Background(), func(context.Context) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 404, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, retryResult{attempts: 1}, result)
+}
+
+func TestRetryPolicyDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var calls int
+	policy := retryPolicy{retries: 5, backoff: 40 * time.Second, statuses: statusSet{{503, 503}}, sleep: func(time.Duration) {}}
+	resp, result, err := policy.do(ctx, func(context.Context) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 503, Status: "503", Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, 2, calls, "the 80s backoff of the second retry should not fit in the budget")
+	assert.Equal(t, retryResult{attempts: 2, lastError: "503", stop: retryStopDeadline}, result)
+
+	// An expired deadline ends the loop whatever the retries left
+	cancel()
+	calls = 0
+	_, result, err = policy.do(ctx, func(ctx context.Context) (*http.Response, error) {
+		calls++
+		return nil, ctx.Err()
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, retryResult{attempts: 1, lastError: errorRequest, stop: retryStopDeadline}, result)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"Tue, 02 Jan 2024 03:04:35 GMT", 30 * time.Second, true},
+		// RFC 850, one of the obsolete formats HTTP dates may still use
+		{"Tuesday, 02-Jan-24 03:05:05 GMT", time.Minute, true},
+		{"Tue, 02 Jan 2024 03:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		wait, ok := parseRetryAfter(test.value, now)
+		assert.Equal(t, test.ok, ok, test.value)
+		assert.Equal(t, test.wait, wait, test.value)
+	}
+}
+
+func TestRetryPolicyRetryAfter(t *testing.T) {
+	var sleeps []time.Duration
+	retryAfter := []string{"3", "", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), ""}
+	var calls int
+	policy := retryPolicy{retries: 4, backoff: time.Second, statuses: statusSet{{429, 429}, {503, 503}}, sleep: func(d time.Duration) { sleeps = append(sleeps, d) }}
+	resp, result, err := policy.do(context.Background(), func(context.Context) (*http.Response, error) {
+		calls++
+		if calls > len(retryAfter) {
+			return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}
+		header := http.Header{}
+		if retryAfter[calls-1] != "" {
+			header.Set("Retry-After", retryAfter[calls-1])
+		}
+		return &http.Response{StatusCode: 503, Status: "503", Header: header, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 5, result.attempts)
+	assert.Equal(t, []time.Duration{3 * time.Second, 2 * time.Second, maxRetryAfter, 8 * time.Second}, sleeps, "Retry-After should replace the backoff, capped")
+
+	// A Retry-After beyond the deadline ends the loop
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	calls = 0
+	_, result, _ = policy.do(ctx, func(context.Context) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 429, Status: "429", Header: http.Header{"Retry-After": {"90"}}, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, retryStopDeadline, result.stop)
+}
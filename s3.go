@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const serviceS3 = "s3"
+
+// s3GlobalRegion is the signing region of the s3.amazonaws.com endpoints,
+// which carry no region.
+const s3GlobalRegion = "us-east-1"
+
+// s3HostRegExp matches S3 endpoints, path-style (s3.<region>.amazonaws.com)
+// or virtual-hosted (<bucket>.s3.<region>.amazonaws.com), including the
+// dualstack, FIPS and legacy s3-<region> forms.
+var s3HostRegExp = regexp.MustCompile(`^(?:(.+)\.)?(s3(?:-fips)?(?:\.dualstack)?(?:[.-]((?:us(?:-gov)?|ap|ca|cn|eu|sa|me|af|il|mx)-[a-z]+-\d+))?\.amazonaws\.com(?:\.cn)?)$`)
+
+// s3Host is an S3 host split into its parts, bucket is empty for path-style
+// hosts and region for the global endpoint.
+type s3Host struct {
+	bucket, endpoint, region string
+}
+
+// parseS3Host splits host, reporting whether it is an S3 endpoint. Buckets
+// are matched whole so dots in their names do not pass for a region.
+func parseS3Host(host string) (s3Host, bool) {
+	result := s3HostRegExp.FindStringSubmatch(strings.ToLower(host))
+	if result == nil {
+		return s3Host{}, false
+	}
+	return s3Host{bucket: result[1], endpoint: result[2], region: result[3]}, true
+}
+
+// s3PathStyle rewrites a virtual-hosted S3 URL to its path-style form, the
+// bucket moving from the host to the first path segment. Other URLs are left
+// as they are.
+func s3PathStyle(u *url.URL) {
+	host, ok := parseS3Host(u.Hostname())
+	if !ok || host.bucket == "" {
+		return
+	}
+	endpoint := host.endpoint
+	if port := u.Port(); port != "" {
+		endpoint += ":" + port
+	}
+	u.Host = endpoint
+	u.Path = "/" + host.bucket + u.Path
+	if u.RawPath != "" {
+		u.RawPath = "/" + host.bucket + u.RawPath
+	}
+}
+
+// newSigner returns a SigV4 signer configured for the service, then by
+// optFns. S3 expects the URI path to be signed as sent rather than escaped a
+// second time.
+func newSigner(service string, optFns ...func(*v4.SignerOptions)) *v4.Signer {
+	return v4.NewSigner(append([]func(*v4.SignerOptions){func(o *v4.SignerOptions) {
+		o.DisableURIPathEscaping = service == serviceS3
+	}}, optFns...)...)
+}
+
+// prepareS3Request adds the payload hash header S3 requires on top of a SigV4
+// signature, it must be set before signing to be covered by it.
+func prepareS3Request(req *http.Request, payloadHash string) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+}
+
+// setContentMD5 sets the base64 encoded MD5 digest of the body, which S3
+// validates against the received bytes.
+func setContentMD5(req *http.Request, body string) {
+	sum := md5.Sum([]byte(body))
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+}
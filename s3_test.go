@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignS3PutWithContentMD5(t *testing.T) {
+	body := "hello world"
+	req, bodyHash, _ := buildRequest("https://my-bucket.s3.eu-west-1.amazonaws.com/some%20key", "PUT", "eu-west-1", body)
+	setContentMD5(req, body)
+	prepareS3Request(req, bodyHash)
+
+	err := newSigner(serviceS3).SignHTTP(context.Background(), testCredentials, req, bodyHash, serviceS3, "eu-west-1", time.Unix(0, 0))
+	assert.Nil(t, err, "should not be any error")
+
+	assert.Equal(t, "XrY7u+Ae7tCTyyK7j1rNww==", req.Header.Get("Content-MD5"))
+	assert.Equal(t, bodyHash, req.Header.Get("X-Amz-Content-Sha256"))
+	assert.Contains(t, req.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request, SignedHeaders=content-length;content-md5;host;x-amz-content-sha256;x-amz-date;x-amz-security-token,")
+}
+
+func TestS3PayloadHashHeader(t *testing.T) {
+	req, bodyHash, _ := buildRequest("https://my-bucket.s3.eu-west-1.amazonaws.com/key", "GET", "eu-west-1", "")
+	prepareS3Request(req, bodyHash)
+
+	assert.Equal(t, emptyPayloadHash, req.Header.Get("X-Amz-Content-Sha256"))
+}
+
+func TestGuessS3Region(t *testing.T) {
+	tests := []struct {
+		url, region string
+	}{
+		{"https://s3.eu-west-1.amazonaws.com/my-bucket/key", "eu-west-1"},
+		{"https://my-bucket.s3.eu-west-1.amazonaws.com/key", "eu-west-1"},
+		{"https://my.bucket.s3.us-west-2.amazonaws.com/key", "us-west-2"},
+		{"https://logs.eu-central-1.archive.s3.ap-southeast-2.amazonaws.com/key", "ap-southeast-2"},
+		{"https://my-bucket.s3-eu-west-1.amazonaws.com/key", "eu-west-1"},
+		{"https://my-bucket.s3.dualstack.us-gov-west-1.amazonaws.com/key", "us-gov-west-1"},
+		{"https://s3.cn-north-1.amazonaws.com.cn/my-bucket/key", "cn-north-1"},
+		{"https://my-bucket.s3.amazonaws.com/key", "us-east-1"},
+		{"https://s3.amazonaws.com/my-bucket/key", "us-east-1"},
+	}
+	for _, test := range tests {
+		region, err := guessAWSRegion(test.url)
+		assert.Nil(t, err, "should not be any error")
+		assert.Equal(t, test.region, region, test.url)
+	}
+}
+
+func TestS3PathStyle(t *testing.T) {
+	tests := []struct {
+		url, pathStyle string
+	}{
+		{"https://my-bucket.s3.eu-west-1.amazonaws.com/some%2Fkey?versionId=1", "https://s3.eu-west-1.amazonaws.com/my-bucket/some%2Fkey?versionId=1"},
+		{"https://my.bucket.s3.amazonaws.com:443/key", "https://s3.amazonaws.com:443/my.bucket/key"},
+		{"https://s3.eu-west-1.amazonaws.com/my-bucket/key", "https://s3.eu-west-1.amazonaws.com/my-bucket/key"},
+		{"http://localhost:9000/my-bucket/key", "http://localhost:9000/my-bucket/key"},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		s3PathStyle(u)
+		assert.Equal(t, test.pathStyle, u.String(), test.url)
+	}
+}
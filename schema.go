@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema is a JSON Schema a -response-schema body is validated against.
+// It is a subset of JSON Schema, the keywords of schemaKeywords: type, enum,
+// const, the numeric, string, array and object constraints, allOf, anyOf,
+// oneOf, not and $ref to a local definition (#/definitions/... or
+// #/$defs/...). Annotations such as format or description are ignored, any
+// other keyword (if, contains, dependentRequired...) fails the compilation
+// rather than being silently skipped.
+type jsonSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// schemaKeywords are the keywords a schema may use, true for those that are
+// validated and false for the annotations that are ignored.
+var schemaKeywords = map[string]bool{
+	"$ref": true, "definitions": true, "$defs": true,
+	"type": true, "enum": true, "const": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true, "multipleOf": true,
+	"minLength": true, "maxLength": true, "pattern": true,
+	"items": true, "prefixItems": true, "additionalItems": true, "minItems": true, "maxItems": true, "uniqueItems": true,
+	"properties": true, "patternProperties": true, "additionalProperties": true, "required": true, "minProperties": true, "maxProperties": true,
+	"allOf": true, "anyOf": true, "oneOf": true, "not": true,
+	"$schema": false, "$id": false, "id": false, "$comment": false, "title": false, "description": false,
+	"default": false, "examples": false, "format": false, "readOnly": false, "writeOnly": false, "deprecated": false,
+	"contentMediaType": false, "contentEncoding": false,
+}
+
+// loadJSONSchema reads and compiles the schema at path.
+func loadJSONSchema(path string) (*jsonSchema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error trying to read -response-schema %s", err)
+	}
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("-response-schema %s is not valid JSON: %s", path, err)
+	}
+	s := &jsonSchema{root: root, patterns: map[string]*regexp.Regexp{}}
+	if err := s.compile(root, "#"); err != nil {
+		return nil, fmt.Errorf("invalid -response-schema %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// compile checks the schema up front, so a broken schema fails the run before
+// any request is sent rather than once the response is in.
+func (s *jsonSchema) compile(schema interface{}, at string) error {
+	switch schema := schema.(type) {
+	case bool:
+		return nil
+	case map[string]interface{}:
+		if ref, ok := schema["$ref"].(string); ok {
+			if _, err := s.resolve(ref); err != nil {
+				return fmt.Errorf("%s: %s", at, err)
+			}
+		}
+		if t, ok := schema["type"]; ok {
+			if err := checkSchemaType(t); err != nil {
+				return fmt.Errorf("%s/type: %s", at, err)
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if err := s.addPattern(pattern); err != nil {
+				return fmt.Errorf("%s/pattern: %s", at, err)
+			}
+		}
+		if properties, ok := schema["patternProperties"].(map[string]interface{}); ok {
+			for pattern := range properties {
+				if err := s.addPattern(pattern); err != nil {
+					return fmt.Errorf("%s/patternProperties: %s", at, err)
+				}
+			}
+		}
+		for _, key := range sortedKeys(schema) {
+			// x- prefixed extensions, as in OpenAPI, are annotations too
+			if _, known := schemaKeywords[key]; !known && !strings.HasPrefix(key, "x-") {
+				return fmt.Errorf("%s: unsupported keyword %q", at, key)
+			}
+			switch key {
+			case "properties", "patternProperties", "definitions", "$defs":
+				if children, ok := schema[key].(map[string]interface{}); ok {
+					for _, name := range sortedKeys(children) {
+						if err := s.compile(children[name], at+"/"+key+"/"+name); err != nil {
+							return err
+						}
+					}
+				}
+			case "items", "allOf", "anyOf", "oneOf", "prefixItems":
+				if children, ok := schema[key].([]interface{}); ok {
+					for i, child := range children {
+						if err := s.compile(child, at+"/"+key+"/"+strconv.Itoa(i)); err != nil {
+							return err
+						}
+					}
+				} else if err := s.compile(schema[key], at+"/"+key); err != nil {
+					return err
+				}
+			case "additionalProperties", "not", "additionalItems":
+				if err := s.compile(schema[key], at+"/"+key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: a schema must be an object or a boolean", at)
+}
+
+// checkSchemaType validates the value of a type keyword, a type name or a
+// list of them.
+func checkSchemaType(t interface{}) error {
+	names, ok := t.([]interface{})
+	if !ok {
+		names = []interface{}{t}
+	}
+	for _, name := range names {
+		switch name {
+		case "null", "boolean", "integer", "number", "string", "array", "object":
+		default:
+			return fmt.Errorf("unknown type %s", compactJSON(name))
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) addPattern(pattern string) error {
+	if _, ok := s.patterns[pattern]; ok {
+		return nil
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	s.patterns[pattern] = r
+	return nil
+}
+
+// resolve returns the schema a local $ref points to, as a JSON pointer from
+// the root of the document.
+func (s *jsonSchema) resolve(ref string) (interface{}, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q, only local references such as #/$defs/name are", ref)
+	}
+	node := s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("$ref %q points to nothing", ref)
+			}
+			node = child
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("$ref %q points to nothing", ref)
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("$ref %q points to nothing", ref)
+		}
+	}
+	return node, nil
+}
+
+// validate returns the violations of the schema by the JSON document in data,
+// each prefixed by the path of the offending value ($ being the document).
+func (s *jsonSchema) validate(data []byte) []string {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("$: the response is not valid JSON: %s", err)}
+	}
+	var errs []string
+	s.check(s.root, value, "$", &errs, 0)
+	return errs
+}
+
+// maxSchemaDepth stops a $ref cycle that never consumes the document.
+const maxSchemaDepth = 64
+
+func (s *jsonSchema) check(schema, value interface{}, path string, errs *[]string, depth int) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+	if depth > maxSchemaDepth {
+		fail("the schema nests $ref deeper than %d levels", maxSchemaDepth)
+		return
+	}
+	var rules map[string]interface{}
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			fail("no value is allowed")
+		}
+		return
+	case map[string]interface{}:
+		rules = schema
+	}
+
+	if ref, ok := rules["$ref"].(string); ok {
+		target, _ := s.resolve(ref)
+		s.check(target, value, path, errs, depth+1)
+	}
+
+	if t, ok := rules["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []interface{}:
+			for _, item := range t {
+				if name, ok := item.(string); ok {
+					types = append(types, name)
+				}
+			}
+		}
+		matched := false
+		for _, name := range types {
+			matched = matched || hasJSONType(value, name)
+		}
+		if !matched {
+			fail("expected %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+			// The other constraints would only repeat the mismatch
+			return
+		}
+	}
+	if enum, ok := rules["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || jsonEqual(allowed, value)
+		}
+		if !found {
+			fail("%s is not one of %s", compactJSON(value), compactJSON(enum))
+		}
+	}
+	if allowed, ok := rules["const"]; ok && !jsonEqual(allowed, value) {
+		fail("expected %s, got %s", compactJSON(allowed), compactJSON(value))
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		s.checkNumber(rules, v, fail)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if min, ok := schemaNumber(rules["minLength"]); ok && float64(length) < min {
+			fail("length %d is shorter than the minimum %v", length, min)
+		}
+		if max, ok := schemaNumber(rules["maxLength"]); ok && float64(length) > max {
+			fail("length %d is longer than the maximum %v", length, max)
+		}
+		if pattern, ok := rules["pattern"].(string); ok && !s.patterns[pattern].MatchString(v) {
+			fail("%q does not match the pattern %q", v, pattern)
+		}
+	case []interface{}:
+		s.checkArray(rules, v, path, errs, depth, fail)
+	case map[string]interface{}:
+		s.checkObject(rules, v, path, errs, depth, fail)
+	}
+
+	if all, ok := rules["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			s.check(sub, value, path, errs, depth+1)
+		}
+	}
+	if anyOf, ok := rules["anyOf"].([]interface{}); ok {
+		if s.matching(anyOf, value, path, depth) == 0 {
+			fail("does not match any of the anyOf schemas")
+		}
+	}
+	if oneOf, ok := rules["oneOf"].([]interface{}); ok {
+		if n := s.matching(oneOf, value, path, depth); n != 1 {
+			fail("matches %d of the oneOf schemas, expected exactly 1", n)
+		}
+	}
+	if not, ok := rules["not"]; ok {
+		var sub []string
+		s.check(not, value, path, &sub, depth+1)
+		if len(sub) == 0 {
+			fail("matches the schema it must not")
+		}
+	}
+}
+
+// matching counts the schemas value is valid against.
+func (s *jsonSchema) matching(schemas []interface{}, value interface{}, path string, depth int) int {
+	n := 0
+	for _, sub := range schemas {
+		var errs []string
+		s.check(sub, value, path, &errs, depth+1)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *jsonSchema) checkNumber(rules map[string]interface{}, n json.Number, fail func(string, ...interface{})) {
+	v, _ := n.Float64()
+	if min, ok := schemaNumber(rules["minimum"]); ok {
+		// Draft 4 makes exclusiveMinimum a boolean modifier of minimum
+		if exclusive, _ := rules["exclusiveMinimum"].(bool); exclusive && v <= min {
+			fail("%s is not greater than %v", n, min)
+		} else if v < min {
+			fail("%s is less than the minimum %v", n, min)
+		}
+	}
+	if max, ok := schemaNumber(rules["maximum"]); ok {
+		if exclusive, _ := rules["exclusiveMaximum"].(bool); exclusive && v >= max {
+			fail("%s is not less than %v", n, max)
+		} else if v > max {
+			fail("%s is greater than the maximum %v", n, max)
+		}
+	}
+	if min, ok := schemaNumber(rules["exclusiveMinimum"]); ok && v <= min {
+		fail("%s is not greater than %v", n, min)
+	}
+	if max, ok := schemaNumber(rules["exclusiveMaximum"]); ok && v >= max {
+		fail("%s is not less than %v", n, max)
+	}
+	if factor, ok := schemaNumber(rules["multipleOf"]); ok && factor > 0 {
+		if q := v / factor; math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("%s is not a multiple of %v", n, factor)
+		}
+	}
+}
+
+func (s *jsonSchema) checkArray(rules map[string]interface{}, items []interface{}, path string, errs *[]string, depth int, fail func(string, ...interface{})) {
+	if min, ok := schemaNumber(rules["minItems"]); ok && float64(len(items)) < min {
+		fail("%d items, fewer than the minimum %v", len(items), min)
+	}
+	if max, ok := schemaNumber(rules["maxItems"]); ok && float64(len(items)) > max {
+		fail("%d items, more than the maximum %v", len(items), max)
+	}
+	if unique, _ := rules["uniqueItems"].(bool); unique {
+		for i := range items {
+			for j := 0; j < i; j++ {
+				if jsonEqual(items[i], items[j]) {
+					fail("items %d and %d are equal, items must be unique", j, i)
+				}
+			}
+		}
+	}
+
+	// Positional schemas: prefixItems (2020-12) or an items array (drafts 4 to 7)
+	tuple, ok := rules["prefixItems"].([]interface{})
+	rest, hasRest := rules["items"]
+	if !ok {
+		if tuple, ok = rules["items"].([]interface{}); ok {
+			rest, hasRest = rules["additionalItems"]
+		}
+	}
+	for i, item := range items {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		if i < len(tuple) {
+			s.check(tuple[i], item, itemPath, errs, depth+1)
+		} else if hasRest {
+			s.check(rest, item, itemPath, errs, depth+1)
+		}
+	}
+}
+
+func (s *jsonSchema) checkObject(rules map[string]interface{}, object map[string]interface{}, path string, errs *[]string, depth int, fail func(string, ...interface{})) {
+	if min, ok := schemaNumber(rules["minProperties"]); ok && float64(len(object)) < min {
+		fail("%d properties, fewer than the minimum %v", len(object), min)
+	}
+	if max, ok := schemaNumber(rules["maxProperties"]); ok && float64(len(object)) > max {
+		fail("%d properties, more than the maximum %v", len(object), max)
+	}
+	if required, ok := rules["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := object[name]; !present {
+					fail("missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	properties, _ := rules["properties"].(map[string]interface{})
+	patternProperties, _ := rules["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := rules["additionalProperties"]
+	for _, name := range sortedKeys(object) {
+		propertyPath := path + "." + name
+		matched := false
+		if sub, ok := properties[name]; ok {
+			matched = true
+			s.check(sub, object[name], propertyPath, errs, depth+1)
+		}
+		for _, pattern := range sortedKeys(patternProperties) {
+			if s.patterns[pattern].MatchString(name) {
+				matched = true
+				s.check(patternProperties[pattern], object[name], propertyPath, errs, depth+1)
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				fail("property %q is not allowed", name)
+			} else {
+				s.check(additional, object[name], propertyPath, errs, depth+1)
+			}
+		}
+	}
+}
+
+// hasJSONType reports whether value is of the JSON Schema type name, an
+// integer being a number without a fractional part.
+func hasJSONType(value interface{}, name string) bool {
+	switch v := value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case json.Number:
+		if name == "number" {
+			return true
+		}
+		f, err := v.Float64()
+		return name == "integer" && err == nil && f == math.Trunc(f)
+	case string:
+		return name == "string"
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	}
+	return false
+}
+
+func jsonTypeName(value interface{}) string {
+	for _, name := range []string{"null", "boolean", "integer", "number", "string", "array", "object"} {
+		if hasJSONType(value, name) {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// jsonEqual compares two decoded values, numbers by value whatever their
+// representation in the documents (1 equals 1.0).
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+func normalizeJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = normalizeJSON(item)
+		}
+		return items
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for name, item := range v {
+			object[name] = normalizeJSON(item)
+		}
+		return object
+	}
+	return value
+}
+
+// schemaNumber reads a numeric keyword of the schema, decoded as a float64.
+func schemaNumber(value interface{}) (float64, bool) {
+	f, ok := value.(float64)
+	return f, ok
+}
+
+func compactJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
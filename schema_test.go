@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSchema writes schema to a file and loads it.
+func writeSchema(t *testing.T, schema string) (*jsonSchema, error) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(schema), 0600))
+	return loadJSONSchema(path)
+}
+
+const orderSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["id", "status", "items"],
+  "properties": {
+    "id": {"type": "integer", "minimum": 1},
+    "status": {"enum": ["pending", "shipped"]},
+    "email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+    "items": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/item"}},
+    "total": {"type": "number", "exclusiveMinimum": 0, "multipleOf": 0.01}
+  },
+  "additionalProperties": false,
+  "$defs": {
+    "item": {
+      "type": "object",
+      "required": ["sku"],
+      "properties": {"sku": {"type": "string", "minLength": 3}, "quantity": {"type": "integer"}}
+    }
+  }
+}`
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schema, err := writeSchema(t, orderSchema)
+	assert.Nil(t, err, "should not be any error")
+
+	assert.Empty(t, schema.validate([]byte(`{"id": 1, "status": "pending", "items": [{"sku": "abc", "quantity": 2}], "total": 19.99}`)))
+	assert.Empty(t, schema.validate([]byte(`{"id": 1.0, "status": "shipped", "items": [{"sku": "abc"}]}`)), "1.0 is an integer")
+
+	assert.Equal(t, []string{
+		`$: missing required property "items"`,
+		`$.email: "nobody" does not match the pattern "^[^@]+@[^@]+$"`,
+		`$: property "extra" is not allowed`,
+		`$.id: 0 is less than the minimum 1`,
+		`$.status: "lost" is not one of ["pending","shipped"]`,
+		`$.total: 0 is not greater than 0`,
+	}, schema.validate([]byte(`{"id": 0, "status": "lost", "email": "nobody", "total": 0, "extra": true}`)))
+
+	assert.Equal(t, []string{
+		`$.id: expected integer, got string`,
+		`$.items[0].sku: length 2 is shorter than the minimum 3`,
+		`$.items[1]: missing required property "sku"`,
+		`$.items[1].quantity: expected integer, got number`,
+		`$.total: 1.005 is not a multiple of 0.01`,
+	}, schema.validate([]byte(`{"id": "1", "status": "pending", "items": [{"sku": "ab"}, {"quantity": 1.5}], "total": 1.005}`)))
+
+	assert.Equal(t, []string{`$: expected object, got array`}, schema.validate([]byte(`[]`)))
+	assert.Len(t, schema.validate([]byte(`{"id":`)), 1, "a malformed body should be reported")
+}
+
+func TestJSONSchemaCombinators(t *testing.T) {
+	schema, err := writeSchema(t, `{
+  "definitions": {"positive": {"type": "number", "minimum": 0}},
+  "properties": {
+    "any": {"anyOf": [{"type": "string"}, {"$ref": "#/definitions/positive"}]},
+    "one": {"oneOf": [{"type": "integer"}, {"type": "number", "maximum": 10}]},
+    "all": {"allOf": [{"type": "string"}, {"maxLength": 2}]},
+    "not": {"not": {"type": "null"}},
+    "tuple": {"type": "array", "items": [{"type": "string"}, {"type": "boolean"}], "additionalItems": false, "uniqueItems": true},
+    "tags": {"type": "object", "patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": {"type": "integer"}},
+    "never": false,
+    "version": {"const": 2}
+  }
+}`)
+	assert.Nil(t, err, "should not be any error")
+
+	assert.Empty(t, schema.validate([]byte(`{"any": 3, "one": 20, "all": "ab", "not": 1, "tuple": ["a", true], "tags": {"x-env": "ci", "count": 3}, "version": 2.0}`)))
+	assert.Equal(t, []string{
+		`$.all: length 3 is longer than the maximum 2`,
+		`$.any: does not match any of the anyOf schemas`,
+		`$.never: no value is allowed`,
+		`$.not: matches the schema it must not`,
+		`$.one: matches 2 of the oneOf schemas, expected exactly 1`,
+		`$.tags.count: expected integer, got string`,
+		`$.tags.x-env: expected string, got integer`,
+		`$.tuple[1]: expected boolean, got string`,
+		`$.tuple[2]: no value is allowed`,
+		`$.version: expected 2, got 3`,
+	}, schema.validate([]byte(`{"any": -1, "one": 5, "all": "abc", "not": null, "tuple": ["a", "b", "c"], "tags": {"x-env": 1, "count": "3"}, "never": 0, "version": 3}`)))
+	assert.Equal(t, []string{`$.tuple: items 0 and 1 are equal, items must be unique`}, schema.validate([]byte(`{"tuple": [1, 1.0]}`))[:1])
+}
+
+func TestLoadInvalidJSONSchema(t *testing.T) {
+	tests := []struct {
+		schema, expected string
+	}{
+		{`{"type": }`, "is not valid JSON"},
+		{`{"properties": {"id": 3}}`, "#/properties/id: a schema must be an object or a boolean"},
+		{`{"type": ["string", "text"]}`, `#/type: unknown type "text"`},
+		{`{"properties": {"id": {"pattern": "("}}}`, "#/properties/id/pattern: error parsing regexp"},
+		{`{"$ref": "https://example.com/schema.json"}`, `unsupported $ref "https://example.com/schema.json"`},
+		{`{"items": {"$ref": "#/$defs/missing"}}`, `#/items: $ref "#/$defs/missing" points to nothing`},
+		{`{"type": "object", "if": {"required": ["id"]}, "then": {"required": ["name"]}}`, `#: unsupported keyword "if"`},
+		{`{"items": {"contains": {"const": 1}}}`, `#/items: unsupported keyword "contains"`},
+		{`{"$defs": {"id": {"dependentRequired": {}}}}`, `#/$defs/id: unsupported keyword "dependentRequired"`},
+	}
+	for _, test := range tests {
+		_, err := writeSchema(t, test.schema)
+		if assert.NotNil(t, err, test.schema) {
+			assert.Contains(t, err.Error(), test.expected, test.schema)
+		}
+	}
+}
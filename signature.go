@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// authorizationHeader is the parsed Authorization header of a SigV4 request.
+type authorizationHeader struct {
+	Credential    string
+	SignedHeaders string
+	Signature     string
+}
+
+// parseAuthorization splits an "AWS4-HMAC-SHA256 Credential=..., SignedHeaders=...,
+// Signature=..." header into its components.
+func parseAuthorization(header string) authorizationHeader {
+	var auth authorizationHeader
+	if i := strings.Index(header, " "); i >= 0 {
+		header = header[i+1:]
+	}
+	for _, component := range strings.Split(header, ",") {
+		component = strings.TrimSpace(component)
+		i := strings.Index(component, "=")
+		if i < 0 {
+			continue
+		}
+		switch value := component[i+1:]; component[:i] {
+		case "Credential":
+			auth.Credential = value
+		case "SignedHeaders":
+			auth.SignedHeaders = value
+		case "Signature":
+			auth.Signature = value
+		}
+	}
+	return auth
+}
+
+// scope returns the credential scope of the signature, date/region/service/
+// aws4_request, the Credential without its access key ID.
+func (a authorizationHeader) scope() string {
+	i := strings.Index(a.Credential, "/")
+	if i < 0 {
+		return ""
+	}
+	return a.Credential[i+1:]
+}
+
+// checkSignature compares the signature of an Authorization header with the
+// expected one, the error shows both along with what was signed so a fixture
+// can be told apart from a real mismatch.
+func checkSignature(authorization, expected string) error {
+	auth := parseAuthorization(authorization)
+	if auth.Signature == strings.ToLower(strings.TrimSpace(expected)) {
+		return nil
+	}
+	return fmt.Errorf("signature mismatch\n- expected: %s\n+ actual:   %s\ncredential: %s\nsigned headers: %s",
+		strings.TrimSpace(expected), auth.Signature, auth.Credential, auth.SignedHeaders)
+}
+
+// parseDateHeader validates the name of the -date-header flag. It must not
+// collide with a header the signer manages itself.
+func parseDateHeader(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	canonical := http.CanonicalHeaderKey(strings.TrimSpace(name))
+	if strings.ContainsAny(canonical, " :\t") {
+		return "", fmt.Errorf("invalid header name %q for -date-header", name)
+	}
+	switch canonical {
+	case "X-Amz-Date", "Authorization", "X-Amz-Security-Token", "X-Amz-Content-Sha256", "Host":
+		return "", fmt.Errorf("-date-header cannot be %s, it is set by the signature", canonical)
+	}
+	return canonical, nil
+}
+
+// Formats of -emit-signed-headers.
+const (
+	signedHeadersJSON  = "json"
+	signedHeadersLines = "lines"
+)
+
+// checkSignedHeadersFormat validates the value of -emit-signed-headers.
+func checkSignedHeadersFormat(format string) error {
+	if format != signedHeadersJSON && format != signedHeadersLines {
+		return fmt.Errorf("invalid signed headers format %q, expected %s or %s", format, signedHeadersJSON, signedHeadersLines)
+	}
+	return nil
+}
+
+// formatSignedHeaders renders the headers of a signed request for another
+// client to send it, Host and Content-Length included since they are part of
+// the signature. json is an object of the values, lines are "Name: value"
+// lines as taken by curl -H.
+func formatSignedHeaders(req *http.Request, format string) (string, error) {
+	if err := checkSignedHeadersFormat(format); err != nil {
+		return "", err
+	}
+	header := req.Header.Clone()
+	header.Set("Host", req.Host)
+	if req.Host == "" {
+		header.Set("Host", req.URL.Host)
+	}
+	if req.ContentLength > 0 {
+		header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	names := headerNames(header)
+
+	if format == signedHeadersJSON {
+		values := make(map[string]string, len(header))
+		for _, name := range names {
+			values[name] = strings.Join(header[name], ", ")
+		}
+		data, err := json.Marshal(values)
+		return string(data), err
+	}
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\n", name, strings.Join(header[name], ", "))
+	}
+	return b.String(), nil
+}
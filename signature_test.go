@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAuthorization = "AWS4-HMAC-SHA256 Credential=AKID/19700101/eu-west-1/lambda/aws4_request, SignedHeaders=content-length;host;x-amz-date;x-amz-security-token, Signature=89d2a4858dac64a1699891c494929097f1c00e65e3bf8dbb99cc625bd7baad12"
+
+func TestParseAuthorization(t *testing.T) {
+	assert.Equal(t, authorizationHeader{
+		Credential:    "AKID/19700101/eu-west-1/lambda/aws4_request",
+		SignedHeaders: "content-length;host;x-amz-date;x-amz-security-token",
+		Signature:     "89d2a4858dac64a1699891c494929097f1c00e65e3bf8dbb99cc625bd7baad12",
+	}, parseAuthorization(testAuthorization))
+	assert.Equal(t, authorizationHeader{}, parseAuthorization(""))
+}
+
+func TestCheckSignature(t *testing.T) {
+	assert.Nil(t, checkSignature(testAuthorization, "89d2a4858dac64a1699891c494929097f1c00e65e3bf8dbb99cc625bd7baad12"))
+	assert.Nil(t, checkSignature(testAuthorization, " 89D2A4858DAC64A1699891C494929097F1C00E65E3BF8DBB99CC625BD7BAAD12\n"), "case and spaces should not matter")
+	assert.EqualError(t, checkSignature(testAuthorization, "deadbeef"), "signature mismatch\n"+
+		"- expected: deadbeef\n"+
+		"+ actual:   89d2a4858dac64a1699891c494929097f1c00e65e3bf8dbb99cc625bd7baad12\n"+
+		"credential: AKID/19700101/eu-west-1/lambda/aws4_request\n"+
+		"signed headers: content-length;host;x-amz-date;x-amz-security-token")
+}
+
+func TestParseDateHeader(t *testing.T) {
+	name, err := parseDateHeader("x-proxy-date")
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "X-Proxy-Date", name)
+
+	name, err = parseDateHeader("")
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "", name)
+
+	_, err = parseDateHeader("x-amz-date")
+	assert.EqualError(t, err, "-date-header cannot be X-Amz-Date, it is set by the signature")
+	_, err = parseDateHeader("Proxy Date")
+	assert.EqualError(t, err, `invalid header name "Proxy Date" for -date-header`)
+}
+
+func TestFormatSignedHeaders(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://abcdef.lambda-url.eu-west-1.on.aws/orders", nil)
+	req.Header.Set("X-Amz-Date", "20240102T030405Z")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKID/20240102/eu-west-1/lambda/aws4_request, SignedHeaders=host;x-amz-date, Signature=abc")
+
+	headers, err := formatSignedHeaders(req, signedHeadersLines)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "Authorization: AWS4-HMAC-SHA256 Credential=AKID/20240102/eu-west-1/lambda/aws4_request, SignedHeaders=host;x-amz-date, Signature=abc\n"+
+		"Host: abcdef.lambda-url.eu-west-1.on.aws\n"+
+		"X-Amz-Date: 20240102T030405Z\n", headers)
+
+	headers, err = formatSignedHeaders(req, signedHeadersJSON)
+	assert.Nil(t, err, "should not be any error")
+	assert.JSONEq(t, `{
+  "Authorization": "AWS4-HMAC-SHA256 Credential=AKID/20240102/eu-west-1/lambda/aws4_request, SignedHeaders=host;x-amz-date, Signature=abc",
+  "Host": "abcdef.lambda-url.eu-west-1.on.aws",
+  "X-Amz-Date": "20240102T030405Z"
+}`, headers)
+	assert.Empty(t, req.Header.Get("Host"), "the request should be left untouched")
+
+	_, err = formatSignedHeaders(req, "yaml")
+	assert.EqualError(t, err, `invalid signed headers format "yaml", expected json or lines`)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const stsAPIVersion = "2011-06-15"
+
+// defaultRoleSessionName names the sessions of -assume-role-arn, as shown in
+// CloudTrail, unless -role-session-name is given.
+const defaultRoleSessionName = "aws-sigv4-action"
+
+// callerIdentity is the result of sts:GetCallerIdentity.
+type callerIdentity struct {
+	Account string `xml:"GetCallerIdentityResult>Account"`
+	Arn     string `xml:"GetCallerIdentityResult>Arn"`
+	UserID  string `xml:"GetCallerIdentityResult>UserId"`
+}
+
+// assumedRole is the result of sts:AssumeRole.
+type assumedRole struct {
+	AccessKeyID     string `xml:"AssumeRoleResult>Credentials>AccessKeyId"`
+	SecretAccessKey string `xml:"AssumeRoleResult>Credentials>SecretAccessKey"`
+	SessionToken    string `xml:"AssumeRoleResult>Credentials>SessionToken"`
+	Expiration      string `xml:"AssumeRoleResult>Credentials>Expiration"`
+	Arn             string `xml:"AssumeRoleResult>AssumedRoleUser>Arn"`
+}
+
+// assumeRoleInput holds the parameters of sts:AssumeRole, ExternalID and Tags
+// are only sent when set.
+type assumeRoleInput struct {
+	RoleARN     string
+	SessionName string
+	ExternalID  string
+	Tags        []roleTag
+}
+
+// roleTag is a session tag of -role-tags.
+type roleTag struct {
+	Key   string
+	Value string
+}
+
+// stsError is the error document returned by the STS query API.
+type stsError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// stsEndpoint returns the regional STS endpoint, in the China partition it
+// lives under amazonaws.com.cn.
+func stsEndpoint(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return "https://sts." + region + ".amazonaws.com.cn/"
+	}
+	return "https://sts." + region + ".amazonaws.com/"
+}
+
+// newSTSClient returns the client of the STS calls, apart from the one of the
+// request: -unix-socket and the redirect policy only apply to the endpoint.
+func newSTSClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: http.DefaultTransport.(*http.Transport).Clone()}
+}
+
+// stsCall signs and sends an action of the STS query API, decoding the XML
+// response into out.
+func stsCall(ctx context.Context, client *http.Client, credentials aws.Credentials, endpoint, region string, params url.Values, out interface{}) error {
+	params.Set("Version", stsAPIVersion)
+	body := params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", formURLEncoded)
+	sum := sha256.Sum256([]byte(body))
+	if err := newSigner("sts").SignHTTP(ctx, credentials, req, hex.EncodeToString(sum[:]), "sts", region, time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e stsError
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return fmt.Errorf("%s: %s", e.Code, e.Message)
+		}
+		return fmt.Errorf("unexpected STS response %s", resp.Status)
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// getCallerIdentity calls sts:GetCallerIdentity to check the credentials are
+// valid before sending the actual request.
+func getCallerIdentity(ctx context.Context, client *http.Client, credentials aws.Credentials, endpoint, region string) (callerIdentity, error) {
+	var identity callerIdentity
+	err := stsCall(ctx, client, credentials, endpoint, region, url.Values{"Action": {"GetCallerIdentity"}}, &identity)
+	return identity, err
+}
+
+// assumeRole calls sts:AssumeRole and returns the temporary credentials of the
+// role, to sign the actual request with.
+func assumeRole(ctx context.Context, client *http.Client, credentials aws.Credentials, endpoint, region string, input assumeRoleInput) (aws.Credentials, string, error) {
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"RoleArn":         {input.RoleARN},
+		"RoleSessionName": {input.SessionName},
+	}
+	if input.ExternalID != "" {
+		params.Set("ExternalId", input.ExternalID)
+	}
+	for i, tag := range input.Tags {
+		params.Set(fmt.Sprintf("Tags.member.%d.Key", i+1), tag.Key)
+		params.Set(fmt.Sprintf("Tags.member.%d.Value", i+1), tag.Value)
+	}
+	var role assumedRole
+	if err := stsCall(ctx, client, credentials, endpoint, region, params, &role); err != nil {
+		return aws.Credentials{}, "", err
+	}
+	if role.AccessKeyID == "" || role.SecretAccessKey == "" {
+		return aws.Credentials{}, "", fmt.Errorf("the AssumeRole response has no credentials")
+	}
+	assumed := aws.Credentials{
+		AccessKeyID:     role.AccessKeyID,
+		SecretAccessKey: role.SecretAccessKey,
+		SessionToken:    role.SessionToken,
+	}
+	if expiration, err := time.Parse(time.RFC3339, role.Expiration); err == nil {
+		assumed.CanExpire, assumed.Expires = true, expiration
+	}
+	return assumed, role.Arn, nil
+}
+
+// parseRoleTags parses the values of -role-tags, key=value session tags each
+// key given at most once, in the order sent to STS.
+func parseRoleTags(values []string) ([]roleTag, error) {
+	tags := make([]roleTag, 0, len(values))
+	seen := map[string]bool{}
+	for _, value := range values {
+		i := strings.Index(value, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid -role-tags %q, expected key=value", value)
+		}
+		key := strings.TrimSpace(value[:i])
+		if seen[key] {
+			return nil, fmt.Errorf("invalid -role-tags %q, tag %s is given twice", value, key)
+		}
+		seen[key] = true
+		tags = append(tags, roleTag{Key: key, Value: strings.TrimSpace(value[i+1:])})
+	}
+	return tags, nil
+}
+
+// maskAccount hides all but the last 4 digits of an account ID, wherever it
+// appears in s.
+func maskAccount(s, account string) string {
+	if len(account) <= 4 {
+		return s
+	}
+	return strings.Replace(s, account, strings.Repeat("*", len(account)-4)+account[len(account)-4:], -1)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCallerIdentity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "Action=GetCallerIdentity&Version=2011-06-15", string(body))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/sts/aws4_request")
+		w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:sts::123456789012:assumed-role/deploy/ci</Arn>
+    <UserId>AROAEXAMPLE:ci</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`))
+	}))
+	defer server.Close()
+
+	identity, err := getCallerIdentity(context.Background(), server.Client(), testCredentials, server.URL, "eu-west-1")
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "123456789012", identity.Account)
+	assert.Equal(t, "arn:aws:sts::123456789012:assumed-role/deploy/ci", identity.Arn)
+	assert.Equal(t, "arn:aws:sts::********9012:assumed-role/deploy/ci", maskAccount(identity.Arn, identity.Account))
+}
+
+func TestGetCallerIdentityInvalidCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>ExpiredToken</Code>
+    <Message>The security token included in the request is expired</Message>
+  </Error>
+</ErrorResponse>`))
+	}))
+	defer server.Close()
+
+	_, err := getCallerIdentity(context.Background(), server.Client(), testCredentials, server.URL, "eu-west-1")
+	assert.EqualError(t, err, "ExpiredToken: The security token included in the request is expired")
+}
+
+func TestSTSEndpoint(t *testing.T) {
+	assert.Equal(t, "https://sts.eu-west-1.amazonaws.com/", stsEndpoint("eu-west-1"))
+	assert.Equal(t, "https://sts.us-gov-west-1.amazonaws.com/", stsEndpoint("us-gov-west-1"))
+	assert.Equal(t, "https://sts.cn-north-1.amazonaws.com.cn/", stsEndpoint("cn-north-1"))
+}
+
+func TestAssumeRole(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, url.Values{
+			"Action":              {"AssumeRole"},
+			"Version":             {"2011-06-15"},
+			"RoleArn":             {"arn:aws:iam::123456789012:role/deploy"},
+			"RoleSessionName":     {"ci"},
+			"ExternalId":          {"partner-42"},
+			"Tags.member.1.Key":   {"team"},
+			"Tags.member.1.Value": {"payments"},
+			"Tags.member.2.Key":   {"env"},
+			"Tags.member.2.Value": {"prod"},
+		}, r.PostForm)
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=AKID/")
+		w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/deploy/ci</Arn>
+      <AssumedRoleId>AROAEXAMPLE:ci</AssumedRoleId>
+    </AssumedRoleUser>
+    <Credentials>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+      <SecretAccessKey>ROLESECRET</SecretAccessKey>
+      <SessionToken>ROLESESSION</SessionToken>
+      <Expiration>2030-01-02T15:04:05Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`))
+	}))
+	defer server.Close()
+
+	input := assumeRoleInput{
+		RoleARN:     "arn:aws:iam::123456789012:role/deploy",
+		SessionName: "ci",
+		ExternalID:  "partner-42",
+		Tags:        []roleTag{{"team", "payments"}, {"env", "prod"}},
+	}
+	credentials, arn, err := assumeRole(context.Background(), server.Client(), testCredentials, server.URL, "eu-west-1", input)
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, "arn:aws:sts::123456789012:assumed-role/deploy/ci", arn)
+	assert.Equal(t, "ASIAEXAMPLE", credentials.AccessKeyID)
+	assert.Equal(t, "ROLESECRET", credentials.SecretAccessKey)
+	assert.Equal(t, "ROLESESSION", credentials.SessionToken)
+	assert.True(t, credentials.CanExpire)
+	assert.Equal(t, time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC), credentials.Expires)
+}
+
+func TestAssumeRoleWithoutOptionalParameters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "Action=AssumeRole&RoleArn=arn%3Aaws%3Aiam%3A%3A123456789012%3Arole%2Fdeploy&RoleSessionName=aws-sigv4-action&Version=2011-06-15", string(body))
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<ErrorResponse><Error><Code>AccessDenied</Code><Message>not authorized to perform sts:AssumeRole</Message></Error></ErrorResponse>`))
+	}))
+	defer server.Close()
+
+	input := assumeRoleInput{RoleARN: "arn:aws:iam::123456789012:role/deploy", SessionName: defaultRoleSessionName}
+	_, _, err := assumeRole(context.Background(), server.Client(), testCredentials, server.URL, "eu-west-1", input)
+	assert.EqualError(t, err, "AccessDenied: not authorized to perform sts:AssumeRole")
+}
+
+func TestParseRoleTags(t *testing.T) {
+	tags, err := parseRoleTags([]string{"team=payments", " env = prod ", "empty="})
+	assert.Nil(t, err, "should not be any error")
+	assert.Equal(t, []roleTag{{"team", "payments"}, {"env", "prod"}, {"empty", ""}}, tags)
+
+	_, err = parseRoleTags([]string{"team"})
+	assert.EqualError(t, err, `invalid -role-tags "team", expected key=value`)
+	_, err = parseRoleTags([]string{"=payments"})
+	assert.EqualError(t, err, `invalid -role-tags "=payments", expected key=value`)
+	_, err = parseRoleTags([]string{"team=a", "team=b"})
+	assert.EqualError(t, err, `invalid -role-tags "team=b", tag team is given twice`)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// traceTimings are the connection timings reported by -trace, in milliseconds.
+// Phases that did not happen (e.g. DNS on a reused connection) are zero.
+type traceTimings struct {
+	DNS              float64 `json:"dns_ms"`
+	Connect          float64 `json:"connect_ms"`
+	TLSHandshake     float64 `json:"tls_handshake_ms"`
+	TimeToFirstByte  float64 `json:"ttfb_ms"`
+	Total            float64 `json:"total_ms"`
+	ReusedConnection bool    `json:"reused_connection"`
+}
+
+// requestTrace records the httptrace events of a single request.
+type requestTrace struct {
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+// withTrace returns a copy of req recording its connection events, requests
+// sent without it carry no tracing overhead.
+func withTrace(req *http.Request) (*http.Request, *requestTrace) {
+	t := &requestTrace{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+		ConnectStart:         func(_, _ string) { t.connectStart = time.Now() },
+		ConnectDone:          func(_, _ string, _ error) { t.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.tlsDone = time.Now() },
+		GotConn:              func(info httptrace.GotConnInfo) { t.reused = info.Reused },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), t
+}
+
+// timings computes the phase durations, end being the moment the response
+// body was fully read.
+func (t *requestTrace) timings(end time.Time) traceTimings {
+	return traceTimings{
+		DNS:              milliseconds(t.dnsStart, t.dnsDone),
+		Connect:          milliseconds(t.connectStart, t.connectDone),
+		TLSHandshake:     milliseconds(t.tlsStart, t.tlsDone),
+		TimeToFirstByte:  milliseconds(t.start, t.firstByte),
+		Total:            milliseconds(t.start, end),
+		ReusedConnection: t.reused,
+	}
+}
+
+func milliseconds(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return float64(to.Sub(from)) / float64(time.Millisecond)
+}
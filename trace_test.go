@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTrace(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := server.Client()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	assert.Nil(t, err, "no error expected here")
+	req, trace := withTrace(req)
+	resp, err := client.Do(req)
+	assert.Nil(t, err, "should not be any error")
+	_, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	timings := trace.timings(time.Now())
+	assert.False(t, timings.ReusedConnection)
+	assert.Greater(t, timings.Connect, 0.0, "connect should have been traced")
+	assert.Greater(t, timings.TLSHandshake, 0.0, "TLS handshake should have been traced")
+	assert.GreaterOrEqual(t, timings.TimeToFirstByte, 10.0, "TTFB should include server processing")
+	assert.GreaterOrEqual(t, timings.Total, timings.TimeToFirstByte)
+
+	req, err = http.NewRequest(http.MethodGet, server.URL, nil)
+	assert.Nil(t, err, "no error expected here")
+	req, trace = withTrace(req)
+	resp, err = client.Do(req)
+	assert.Nil(t, err, "should not be any error")
+	resp.Body.Close()
+
+	timings = trace.timings(time.Now())
+	assert.True(t, timings.ReusedConnection)
+	assert.Zero(t, timings.TLSHandshake, "reused connections skip the handshake")
+}